@@ -1,13 +1,43 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"os"
+	"p2pfs/internal/config"
+	"p2pfs/internal/fs"
 	"p2pfs/internal/gui"
+	"p2pfs/internal/identity"
 	"p2pfs/internal/peer"
+	"p2pfs/internal/pki"
 	"time"
 )
 
 func main() {
+	// Subcomandos de certificados (ca init, cert issue)
+	if runPKICommand(os.Args[1:]) {
+		return
+	}
+
+	configPath := flag.String("config", config.DefaultFile, "archivo de configuración del clúster")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Println("❌", err)
+		os.Exit(1)
+	}
+	config.Current = cfg
+
+	// 🔑 Identidad Ed25519 persistente del nodo
+	id, err := identity.Load(identity.DefaultFile())
+	if err != nil {
+		fmt.Println("❌ No se pudo cargar la identidad del nodo:", err)
+		os.Exit(1)
+	}
+	identity.Current = id
+	fmt.Println("🔑 NodeID:", id.NodeID)
+
 	// Configuración inicial sin ID (se asignará dinámicamente)
 	port := "8001"
 	localIP := peer.GetLocalIP()
@@ -15,45 +45,85 @@ func main() {
 
 	// Crear nodo sin ID (será asignado luego)
 	self := &peer.Peer{
-		ID:    0, // ID aún no asignado
-		IP:    localIP,
-		Port:  port,
-		Peers: []peer.PeerInfo{},
+		ID:     0, // ID aún no asignado
+		NodeID: id.NodeID,
+		IP:     localIP,
+		Port:   port,
+	}
+
+	// 💾 Recuperar ID y peers de la ejecución anterior
+	if self.RestoreState() {
+		fmt.Printf("💾 ID %d recuperado, %d peers conocidos\n", self.ID, len(self.Peers()))
+	}
+
+	// 🔒 TLS mutuo entre nodos
+	if cfg.TLS.Enabled {
+		self.ServerTLS, err = pki.ServerConfig(cfg.TLS.CAFile, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err == nil {
+			self.ClientTLS, err = pki.ClientConfig(cfg.TLS.CAFile, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		}
+		if err != nil {
+			fmt.Println("❌ No se pudo activar TLS:", err)
+			os.Exit(1)
+		}
+		fmt.Println("🔒 TLS mutuo activado")
+	}
+
+	if cfg.Discovery.Secret == "" {
+		fmt.Println("⚠️  Sin secreto de clúster: los anuncios UDP no se autentican")
 	}
 
 	// 🔊 Listener para handshakes y mensajes UDP
-	go peer.ListenForBroadcasts(self, func() []peer.PeerInfo {
-		return self.Peers
-	})
+	go peer.ListenForBroadcasts(self, self.Peers)
 
-	// 📣 Broadcast activo mientras no tenga ID
+	// 📣 HELLO mientras no tenga ID (o REJOIN si lo recuperó)
 	go peer.BroadcastHello(self)
 
 	// 🧠 Iniciar listener TCP de archivos, SYNC, etc.
 	go self.StartListener()
 
+	// 📦 Con factor de replicación, solo traer por SYNC lo que le toca a este nodo
+	fs.Holds = self.Holds
+
+	// 👥 Membresía y detección de fallos (SWIM)
+	go self.StartMembership()
+
+	// 🔄 Con ID recuperado, ponerse al día con lo que pasó mientras estaba caído
+	if self.ID != 0 {
+		go func() {
+			time.Sleep(2 * peer.ProbeInterval)
+			self.CatchUp()
+		}()
+	}
+
 	// ♻️ Reintentos de envío de archivos fallidos
 	go self.RetryWorker(10 * time.Second)
-  // Si después de 5 segundos no se ha recibido ID, autoasignar
-  go func() {
-
-	  time.Sleep(5 * time.Second)
-	  if self.ID == 0 {
-		  fmt.Println("⚠️  No se recibió ASSIGN_ID. Asignando ID=1 como nodo inicial.")
-		  self.ID = 1
-		  self.LastIDAssigned = time.Now()
-
-		  newNode := peer.NodeAnnouncement{
-			  Type: "NEW_NODE",
-			  IP:   self.IP,
-			  Port: self.Port,
-			  ID:   self.ID,
-		  }
-		  peer.BroadcastNewNode(newNode)
-	  }
-  }()
+
+	// 👀 Replicar los cambios hechos a mano en shared/
+	go self.StartWatcher("shared")
+
+	// 🌳 Anti-entropía: comparar shared/ con otro peer y reparar diferencias
+	go self.StartAntiEntropy()
+
+	// 🩹 Restaurar réplicas perdidas cuando cae un nodo
+	go self.StartRepair()
+
+	// 🪦 Descarte de lápidas confirmadas por todos los nodos
+	go self.StartTombstoneGC()
+
+	// 🧹 Borrar del almacén las versiones sin referencias
+	go self.StartStoreGC()
+
+	// Si después de 5 segundos nadie propuso un ID, reclamar el primero libre
+	go func() {
+		time.Sleep(5 * time.Second)
+		if self.ID == 0 {
+			fmt.Println("⚠️  No se recibió ASSIGN_ID. Reclamando el primer ID libre.")
+			peer.ClaimFreeID(self)
+		}
+	}()
 
 	// 🖼️ Interfaz gráfica
-	gui.StartGUI(self.ID, self.Peers, self)
+	gui.StartGUI(self.ID, self)
 }
 
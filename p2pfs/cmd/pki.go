@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"p2pfs/internal/config"
+	"p2pfs/internal/peer"
+	"p2pfs/internal/pki"
+)
+
+// runPKICommand atiende los subcomandos de certificados:
+//
+//	p2pfs ca init [--dir data/tls] [--name p2pfs-ca]
+//	p2pfs cert issue [--name node] [--ip 192.168.0.6,...] [--ca data/tls] [--out data/tls]
+//
+// Retorna false si args no corresponde a ninguno.
+func runPKICommand(args []string) bool {
+	if len(args) < 2 {
+		return false
+	}
+
+	switch args[0] + " " + args[1] {
+	case "ca init":
+		fset := flag.NewFlagSet("ca init", flag.ExitOnError)
+		dir := fset.String("dir", config.Path("tls"), "directorio donde guardar la CA")
+		name := fset.String("name", "p2pfs-ca", "nombre de la CA")
+		fset.Parse(args[2:])
+
+		if err := pki.InitCA(*dir, *name); err != nil {
+			fmt.Println("❌ Error al crear la CA:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("🔐 CA creada en %s. Copie %s a cada nodo y guarde %s en un lugar seguro.\n",
+			*dir, pki.CACertFile, pki.CAKeyFile)
+
+	case "cert issue":
+		fset := flag.NewFlagSet("cert issue", flag.ExitOnError)
+		caDir := fset.String("ca", config.Path("tls"), "directorio de la CA")
+		outDir := fset.String("out", config.Path("tls"), "directorio de salida")
+		name := fset.String("name", "node", "nombre del certificado (<name>.crt / <name>.key)")
+		ipList := fset.String("ip", peer.GetLocalIP(), "IPs del nodo separadas por comas")
+		fset.Parse(args[2:])
+
+		var ips []net.IP
+		for _, s := range strings.Split(*ipList, ",") {
+			if ip := net.ParseIP(strings.TrimSpace(s)); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+		if err := pki.IssueCert(*caDir, *outDir, *name, ips); err != nil {
+			fmt.Println("❌ Error al emitir certificado:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("📜 Certificado %s.crt emitido en %s\n", *name, *outDir)
+
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// DataDir es el directorio de datos del nodo (almacén de bloques, estado
+// persistente). Se puede cambiar con la variable de entorno DATA_DIR.
+var DataDir = getEnvOrDefault("DATA_DIR", "data")
+
+// DefaultFile es el archivo de configuración del clúster por defecto.
+const DefaultFile = "config/cluster.json"
+
+// Config agrupa las opciones del nodo que se leen de config/cluster.json.
+type Config struct {
+	TLS         TLSConfig         `json:"tls"`
+	Discovery   DiscoveryConfig   `json:"discovery"`
+	Conflicts   ConflictConfig    `json:"conflicts"`
+	Replication ReplicationConfig `json:"replication"`
+	Quorum      QuorumConfig      `json:"quorum"`
+}
+
+// TLSConfig controla el TLS mutuo entre nodos.
+type TLSConfig struct {
+	Enabled  bool   `json:"enabled"`
+	CAFile   string `json:"ca_file"`   // Certificado de la CA del clúster
+	CertFile string `json:"cert_file"` // Certificado de este nodo
+	KeyFile  string `json:"key_file"`  // Clave privada de este nodo
+}
+
+// DiscoveryConfig controla la autenticación de los anuncios UDP.
+type DiscoveryConfig struct {
+	// Secreto compartido del clúster para el HMAC de HELLO/ASSIGN_ID/NEW_NODE.
+	// Vacío = anuncios sin autenticar (compatibilidad). CLUSTER_SECRET lo reemplaza.
+	Secret string `json:"secret"`
+	// Desfase máximo aceptado entre el timestamp del anuncio y el reloj local
+	MaxSkewSeconds int64 `json:"max_skew_seconds"`
+}
+
+// Políticas de resolución de conflictos entre escrituras concurrentes
+const (
+	ConflictLWW      = "lww"       // Gana la última escritura (HLC); empate por NodeID
+	ConflictKeepBoth = "keep-both" // Gana la última; la otra queda como "nombre (conflict from node N)"
+	ConflictManual   = "manual"    // No se toca el archivo; se resuelve a mano y queda en el oplog
+)
+
+// ConflictConfig controla qué hacer cuando dos nodos modifican la misma
+// ruta sin haber visto el cambio del otro.
+type ConflictConfig struct {
+	// Una de ConflictLWW, ConflictKeepBoth o ConflictManual. CONFLICT_POLICY la reemplaza.
+	Policy string `json:"policy"`
+}
+
+// ReplicationConfig controla en cuántos nodos se guarda cada archivo.
+type ReplicationConfig struct {
+	// Réplicas por archivo; 0 = en todos los nodos (comportamiento anterior)
+	Factor int `json:"factor"`
+	// Puntos por nodo en el anillo de hashing consistente
+	VirtualNodes int `json:"virtual_nodes"`
+}
+
+// QuorumConfig fija cuántas réplicas deben responder en escrituras y lecturas.
+type QuorumConfig struct {
+	// Réplicas que deben confirmar una copia verificada para dar un envío por bueno
+	Write int `json:"write"`
+	// Réplicas que se consultan al leer los metadatos de un archivo
+	Read int `json:"read"`
+}
+
+// Current es la configuración activa; main la reemplaza con Load.
+var Current = Default()
+
+// Default retorna la configuración usada si no hay archivo
+func Default() *Config {
+	return &Config{
+		TLS: TLSConfig{
+			Enabled:  false,
+			CAFile:   Path("tls", "ca.crt"),
+			CertFile: Path("tls", "node.crt"),
+			KeyFile:  Path("tls", "node.key"),
+		},
+		Discovery: DiscoveryConfig{
+			MaxSkewSeconds: 30,
+		},
+		Conflicts: ConflictConfig{
+			Policy: ConflictLWW,
+		},
+		Replication: ReplicationConfig{
+			Factor:       0,
+			VirtualNodes: 64,
+		},
+		Quorum: QuorumConfig{
+			Write: 1,
+			Read:  1,
+		},
+	}
+}
+
+// Load lee la configuración de path. Si el archivo no existe se usan los
+// valores por defecto; los campos ausentes conservan su valor por defecto.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, fmt.Errorf("no se pudo leer la configuración: %w", err)
+	default:
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("configuración inválida en %s: %w", path, err)
+		}
+	}
+
+	// El secreto puede venir del entorno para no guardarlo en el archivo
+	if secret, ok := os.LookupEnv("CLUSTER_SECRET"); ok {
+		cfg.Discovery.Secret = secret
+	}
+	cfg.Conflicts.Policy = getEnvOrDefault("CONFLICT_POLICY", cfg.Conflicts.Policy)
+
+	switch cfg.Conflicts.Policy {
+	case ConflictLWW, ConflictKeepBoth, ConflictManual:
+	default:
+		return nil, fmt.Errorf("política de conflictos desconocida: %q", cfg.Conflicts.Policy)
+	}
+	if cfg.Replication.Factor < 0 || cfg.Replication.VirtualNodes <= 0 {
+		return nil, fmt.Errorf("replicación inválida (factor=%d, virtual_nodes=%d)",
+			cfg.Replication.Factor, cfg.Replication.VirtualNodes)
+	}
+	if err := cfg.validateQuorum(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Path construye una ruta dentro del directorio de datos
+func Path(elem ...string) string {
+	return filepath.Join(append([]string{DataDir}, elem...)...)
+}
+
+func getEnvOrDefault(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// validateQuorum exige 1 <= W, R <= N y W + R > N, con N el factor de
+// replicación, para que toda lectura vea al menos una réplica de la última
+// escritura confirmada. Con factor 0 N es el tamaño del clúster, que no se
+// conoce al cargar: solo se comprueba el mínimo y el resto al usarlos.
+func (cfg *Config) validateQuorum() error {
+	w, r, n := cfg.Quorum.Write, cfg.Quorum.Read, cfg.Replication.Factor
+	if w < 1 || r < 1 {
+		return fmt.Errorf("quórum inválido (write=%d, read=%d): ambos deben ser al menos 1", w, r)
+	}
+	if n == 0 {
+		return nil
+	}
+	if w > n || r > n {
+		return fmt.Errorf("quórum inválido (write=%d, read=%d): ninguno puede superar el factor de replicación %d", w, r, n)
+	}
+	if w+r <= n {
+		return fmt.Errorf("quórum inválido (write=%d, read=%d): write + read debe superar el factor de replicación %d", w, r, n)
+	}
+	return nil
+}
@@ -0,0 +1,332 @@
+package delta
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Transferencia delta al estilo rsync: el receptor calcula la firma de su
+// copia (checksum rodante + SHA-256 por bloque), el emisor recorre su
+// versión buscando bloques que el receptor ya tiene y envía solo
+// referencias a esos bloques más los datos literales que cambiaron.
+
+const (
+	MinBlockSize = 2 << 10
+	MaxBlockSize = 64 << 10
+
+	// MaxLiteral limita el tamaño de cada operación literal
+	MaxLiteral = 1 << 20
+)
+
+// Tipos de operación delta
+const (
+	OpCopy    byte = 'C' // Copiar bloques de la copia del receptor
+	OpLiteral byte = 'L' // Datos nuevos
+	OpEnd     byte = 'E' // Fin del delta
+)
+
+var ErrBadOp = errors.New("operación delta inválida")
+
+// BlockSig es la firma de un bloque de la copia del receptor.
+type BlockSig struct {
+	Weak   uint32 `json:"weak"`
+	Strong string `json:"strong"`
+	Size   int    `json:"size"`
+}
+
+// Signature es la firma completa de un archivo.
+type Signature struct {
+	BlockSize int        `json:"block_size"`
+	Blocks    []BlockSig `json:"blocks"`
+}
+
+// Op es una instrucción para reconstruir el archivo nuevo.
+type Op struct {
+	Kind  byte
+	Index int    // Primer bloque a copiar (OpCopy)
+	Count int    // Bloques consecutivos a copiar (OpCopy)
+	Data  []byte // Datos literales (OpLiteral)
+}
+
+// BlockSizeFor elige un tamaño de bloque proporcional a √size
+func BlockSizeFor(size int64) int {
+	bs := int(math.Sqrt(float64(size)))
+	bs = (bs + 1023) &^ 1023
+	if bs < MinBlockSize {
+		return MinBlockSize
+	}
+	if bs > MaxBlockSize {
+		return MaxBlockSize
+	}
+	return bs
+}
+
+// rolling es el checksum débil de rsync (dos sumas de 16 bits)
+type rolling struct {
+	a, b uint32
+	n    uint32
+}
+
+func newRolling(block []byte) rolling {
+	var r rolling
+	r.n = uint32(len(block))
+	for i, c := range block {
+		r.a += uint32(c)
+		r.b += (r.n - uint32(i)) * uint32(c)
+	}
+	return r
+}
+
+func (r rolling) sum() uint32 {
+	return (r.a & 0xffff) | (r.b&0xffff)<<16
+}
+
+// roll desplaza la ventana un byte: sale out, entra in
+func (r *rolling) roll(out, in byte) {
+	r.a = r.a - uint32(out) + uint32(in)
+	r.b = r.b - r.n*uint32(out) + r.a
+}
+
+// shrink quita out del inicio de la ventana sin añadir nada (final del archivo)
+func (r *rolling) shrink(out byte) {
+	r.a -= uint32(out)
+	r.b -= r.n * uint32(out)
+	r.n--
+}
+
+// ComputeSignature calcula la firma de r con bloques de blockSize
+func ComputeSignature(r io.Reader, blockSize int) (Signature, error) {
+	sig := Signature{BlockSize: blockSize}
+	buf := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			sig.Blocks = append(sig.Blocks, BlockSig{
+				Weak:   newRolling(buf[:n]).sum(),
+				Strong: fmt.Sprintf("%x", sha256.Sum256(buf[:n])),
+				Size:   n,
+			})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sig, nil
+		}
+		if err != nil {
+			return sig, err
+		}
+	}
+}
+
+// Diff recorre r (la versión nueva) y emite las operaciones que
+// transforman la copia firmada en ella. Solo mantiene en memoria una
+// ventana de un bloque más el literal pendiente.
+func Diff(sig Signature, r io.Reader, emit func(Op) error) error {
+	table := make(map[uint32][]int)
+	for i, b := range sig.Blocks {
+		table[b.Weak] = append(table[b.Weak], i)
+	}
+
+	br := bufio.NewReaderSize(r, 64<<10)
+	var literal []byte
+	pendingCopy := Op{Kind: OpCopy, Index: -1}
+
+	// La ventana es buf[start:end]; buf mide dos bloques para desplazarla
+	// sin copiar en cada byte (se compacta solo al llegar al final)
+	buf := make([]byte, 2*sig.BlockSize)
+	start, end := 0, 0
+
+	flushLiteral := func() error {
+		if len(literal) == 0 {
+			return nil
+		}
+		err := emit(Op{Kind: OpLiteral, Data: literal})
+		literal = nil
+		return err
+	}
+	flushCopy := func() error {
+		if pendingCopy.Count == 0 {
+			return nil
+		}
+		err := emit(pendingCopy)
+		pendingCopy = Op{Kind: OpCopy, Index: -1}
+		return err
+	}
+	fill := func() error {
+		start, end = 0, 0
+		for end < sig.BlockSize {
+			c, err := br.ReadByte()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			buf[end] = c
+			end++
+		}
+		return nil
+	}
+
+	if err := fill(); err != nil {
+		return err
+	}
+	roll := newRolling(buf[start:end])
+
+	for end > start {
+		if match := lookup(sig, table, roll.sum(), buf[start:end]); match >= 0 {
+			if err := flushLiteral(); err != nil {
+				return err
+			}
+			// Agrupar copias de bloques consecutivos
+			if pendingCopy.Count > 0 && pendingCopy.Index+pendingCopy.Count == match {
+				pendingCopy.Count++
+			} else {
+				if err := flushCopy(); err != nil {
+					return err
+				}
+				pendingCopy = Op{Kind: OpCopy, Index: match, Count: 1}
+			}
+			if err := fill(); err != nil {
+				return err
+			}
+			roll = newRolling(buf[start:end])
+			continue
+		}
+
+		if err := flushCopy(); err != nil {
+			return err
+		}
+
+		// Sin coincidencia: el primer byte pasa al literal y la ventana avanza
+		out := buf[start]
+		literal = append(literal, out)
+		if len(literal) >= MaxLiteral {
+			if err := flushLiteral(); err != nil {
+				return err
+			}
+		}
+
+		c, err := br.ReadByte()
+		switch {
+		case err == nil:
+			if end == len(buf) {
+				copy(buf, buf[start:end])
+				end -= start
+				start = 0
+			}
+			buf[end] = c
+			end++
+			start++
+			roll.roll(out, c)
+		case err == io.EOF:
+			start++
+			roll.shrink(out)
+		default:
+			return err
+		}
+	}
+
+	if err := flushCopy(); err != nil {
+		return err
+	}
+	if err := flushLiteral(); err != nil {
+		return err
+	}
+	return emit(Op{Kind: OpEnd})
+}
+
+// lookup busca un bloque de la firma igual a window
+func lookup(sig Signature, table map[uint32][]int, weak uint32, window []byte) int {
+	candidates, ok := table[weak]
+	if !ok {
+		return -1
+	}
+	var strong string
+	for _, i := range candidates {
+		if sig.Blocks[i].Size != len(window) {
+			continue
+		}
+		if strong == "" {
+			strong = fmt.Sprintf("%x", sha256.Sum256(window))
+		}
+		if sig.Blocks[i].Strong == strong {
+			return i
+		}
+	}
+	return -1
+}
+
+// Apply ejecuta una operación escribiendo en w; los bloques copiados se
+// leen de base (la copia del receptor)
+func Apply(op Op, sig Signature, base io.ReaderAt, w io.Writer) (int64, error) {
+	switch op.Kind {
+	case OpLiteral:
+		n, err := w.Write(op.Data)
+		return int64(n), err
+
+	case OpCopy:
+		if op.Index < 0 || op.Count <= 0 || op.Index+op.Count > len(sig.Blocks) {
+			return 0, fmt.Errorf("%w: copia de bloques %d+%d", ErrBadOp, op.Index, op.Count)
+		}
+		var written int64
+		for i := op.Index; i < op.Index+op.Count; i++ {
+			buf := make([]byte, sig.Blocks[i].Size)
+			if _, err := base.ReadAt(buf, int64(i)*int64(sig.BlockSize)); err != nil && err != io.EOF {
+				return written, err
+			}
+			n, err := w.Write(buf)
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+		}
+		return written, nil
+
+	case OpEnd:
+		return 0, nil
+	}
+	return 0, fmt.Errorf("%w: %q", ErrBadOp, op.Kind)
+}
+
+// EncodeOp serializa una operación para enviarla en una trama DELTA
+func EncodeOp(op Op) []byte {
+	switch op.Kind {
+	case OpCopy:
+		buf := make([]byte, 9)
+		buf[0] = OpCopy
+		binary.BigEndian.PutUint32(buf[1:5], uint32(op.Index))
+		binary.BigEndian.PutUint32(buf[5:9], uint32(op.Count))
+		return buf
+	case OpLiteral:
+		return append([]byte{OpLiteral}, op.Data...)
+	default:
+		return []byte{op.Kind}
+	}
+}
+
+// DecodeOp interpreta el payload de una trama DELTA
+func DecodeOp(payload []byte) (Op, error) {
+	if len(payload) == 0 {
+		return Op{}, ErrBadOp
+	}
+	switch payload[0] {
+	case OpCopy:
+		if len(payload) != 9 {
+			return Op{}, ErrBadOp
+		}
+		return Op{
+			Kind:  OpCopy,
+			Index: int(binary.BigEndian.Uint32(payload[1:5])),
+			Count: int(binary.BigEndian.Uint32(payload[5:9])),
+		}, nil
+	case OpLiteral:
+		return Op{Kind: OpLiteral, Data: payload[1:]}, nil
+	case OpEnd:
+		return Op{Kind: OpEnd}, nil
+	}
+	return Op{}, fmt.Errorf("%w: %q", ErrBadOp, payload[0])
+}
@@ -0,0 +1,213 @@
+package fs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"p2pfs/internal/config"
+	"p2pfs/internal/log"
+	"p2pfs/internal/store"
+)
+
+// Conflictos: dos operaciones sobre la misma ruta son concurrentes si
+// ninguna desciende de la otra (ver log.History). Según
+// config.Current.Conflicts.Policy:
+//
+//   - lww: gana la posterior según el HLC; a igual marca, el mayor NodeID.
+//   - keep-both: igual que lww, pero si la perdedora es un TRANSFER su
+//     contenido queda en "nombre (conflict from node N).ext".
+//   - manual: el archivo no se toca y queda un CONFLICT en el oplog hasta
+//     que se llame a ResolveConflict.
+//
+// Todos los nodos eligen el mismo ganador, así que convergen sin tener que
+// replicar la resolución (salvo en modo manual).
+
+// Conflict es un conflicto pendiente de resolución manual
+type Conflict struct {
+	Path   string
+	Local  log.Operation // Versión que quedó en disco
+	Remote log.Operation // Versión concurrente sin aplicar
+}
+
+// follows indica si op viene después de prev en la historia de su ruta. Con
+// operaciones de logs antiguos (sin ID) se usa el orden HLC.
+func follows(h log.History, prev, op log.Operation) bool {
+	if prev.ID() == "" || op.ID() == "" {
+		return prev.Before(op)
+	}
+	return h.Descends(op, prev.ID())
+}
+
+// superseded indica si op ya es historia de prev (prev desciende de ella)
+func superseded(h log.History, prev, op log.Operation) bool {
+	if prev.ID() == "" || op.ID() == "" {
+		return !prev.Before(op)
+	}
+	return h.Descends(prev, op.ID())
+}
+
+// equivalent indica si dos operaciones dejan la ruta igual, aunque sean
+// concurrentes (p. ej. el envío y la recepción del mismo archivo)
+func equivalent(a, b log.Operation) bool {
+	if a.Type != b.Type {
+		return false
+	}
+	return a.Type == "DELETE" || a.Hash == b.Hash
+}
+
+// resolveConflict decide entre la operación local (en disco) y una remota
+// concurrente, y retorna la que queda en disco
+func resolveConflict(local, remote log.Operation) (log.Operation, error) {
+	target := local.Target()
+	policy := config.Current.Conflicts.Policy
+
+	if policy == config.ConflictManual {
+		log.AppendToLocalLog(log.Operation{
+			Type:    "CONFLICT",
+			Path:    target,
+			Parents: []string{local.ID(), remote.ID()},
+			Time:    time.Now().Unix(),
+			Message: fmt.Sprintf("%s de %s concurrente con la versión local, pendiente de resolución manual", remote.Type, remote.NodeID),
+		})
+		fmt.Printf("⚠️ Conflicto en %s: %s de %s sin aplicar hasta resolverlo\n", target, remote.Type, remote.NodeID)
+		return local, nil
+	}
+
+	winner, loser := local, remote
+	remoteWins := local.Before(remote)
+	if remoteWins {
+		winner, loser = remote, local
+	}
+
+	if policy == config.ConflictKeepBoth && loser.Type == "TRANSFER" {
+		if err := keepCopy(loser, !remoteWins); err != nil {
+			return local, err
+		}
+	}
+	if remoteWins {
+		if err := ApplyOperation(remote); err != nil {
+			return local, err
+		}
+	}
+
+	log.AppendToLocalLog(log.Operation{
+		Type:    "CONFLICT_RESOLVED",
+		Path:    target,
+		Parents: []string{local.ID(), remote.ID()},
+		Time:    time.Now().Unix(),
+		Message: fmt.Sprintf("Conflicto resuelto (%s): gana %s de %s", policy, winner.Type, winner.NodeID),
+	})
+	fmt.Printf("⚔️ Conflicto en %s resuelto (%s): gana %s de %s\n", target, policy, winner.Type, winner.NodeID)
+	return winner, nil
+}
+
+// keepCopy guarda el contenido de un TRANSFER perdedor junto al original.
+// onDisk indica que es la versión que hoy está en la ruta.
+func keepCopy(op log.Operation, onDisk bool) error {
+	dest := ConflictCopyName(op.Target(), op.NodeID)
+	MarkIncoming(dest)
+	if op.Hash != "" && store.Complete(op.Hash) {
+		return store.Assemble(op.Hash, dest)
+	}
+	if !onDisk {
+		return fmt.Errorf("contenido de %s no disponible para la copia de conflicto", op.Target())
+	}
+	data, err := os.ReadFile(op.Target())
+	if err != nil {
+		return fmt.Errorf("error al copiar versión en conflicto: %w", err)
+	}
+	return os.WriteFile(dest, data, 0644)
+}
+
+// ConflictCopyName retorna la ruta de la copia de conflicto de path:
+// "informe.txt" → "informe (conflict from node <NodeID>).txt"
+func ConflictCopyName(path, nodeID string) string {
+	dir, base := filepath.Split(path)
+	ext := filepath.Ext(base)
+	name := strings.TrimSuffix(base, ext)
+	return filepath.Join(dir, fmt.Sprintf("%s (conflict from node %s)%s", name, nodeID, ext))
+}
+
+// PendingConflicts retorna los conflictos registrados en modo manual que
+// ninguna operación posterior resolvió
+func PendingConflicts() []Conflict {
+	ops := log.ReadLocalLog()
+	h := log.NewHistory(ops)
+
+	var out []Conflict
+	for _, ev := range ops {
+		if ev.Type != "CONFLICT" || len(ev.Parents) != 2 {
+			continue
+		}
+		local, okL := h[ev.Parents[0]]
+		remote, okR := h[ev.Parents[1]]
+		if !okL || !okR || resolved(ops, h, ev.Parents) {
+			continue
+		}
+		out = append(out, Conflict{Path: ev.Path, Local: local, Remote: remote})
+	}
+	return out
+}
+
+// resolved indica si alguna operación del log desciende de todas las ids
+func resolved(ops []log.Operation, h log.History, ids []string) bool {
+	for _, op := range ops {
+		if !log.Replicated(op.Type) {
+			continue
+		}
+		all := true
+		for _, id := range ids {
+			if !h.Descends(op, id) {
+				all = false
+				break
+			}
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveConflict resuelve a mano los conflictos pendientes de path
+// quedándose con la versión keepID. La decisión se registra como una
+// operación nueva que desciende de todas las versiones en conflicto, así
+// que se replica y el resto de los nodos la aplica sin nuevo conflicto.
+func ResolveConflict(path, keepID string) error {
+	path = filepath.Clean(path)
+	var keep *log.Operation
+	for _, c := range PendingConflicts() {
+		if c.Path != path {
+			continue
+		}
+		for _, op := range []log.Operation{c.Local, c.Remote} {
+			if op.ID() == keepID {
+				op := op
+				keep = &op
+			}
+		}
+	}
+	if keep == nil {
+		return fmt.Errorf("no hay un conflicto pendiente en %s con la versión %s", path, keepID)
+	}
+
+	if err := ApplyOperation(*keep); err != nil {
+		return err
+	}
+	// Sin Parents explícitos: AppendToLocalLog usa las cabezas de la ruta,
+	// que son justamente las versiones en conflicto
+	log.AppendToLocalLog(log.Operation{
+		Type:     keep.Type,
+		Path:     keep.Path,
+		FileName: keep.FileName,
+		Hash:     keep.Hash,
+		Size:     keep.Size,
+		Time:     time.Now().Unix(),
+		Message:  fmt.Sprintf("Conflicto resuelto manualmente: se conserva la versión %s", keepID),
+	})
+	fmt.Printf("✅ Conflicto en %s resuelto: versión %s\n", path, keepID)
+	return nil
+}
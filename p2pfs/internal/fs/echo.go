@@ -0,0 +1,77 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"p2pfs/internal/log"
+)
+
+// Supresión de ecos: cuando una operación recibida escribe o borra en
+// shared/, el watcher ve el evento como si fuera un cambio local. Las
+// escrituras hechas por el propio nodo se marcan aquí y, además, un archivo
+// cuyo contenido ya es el de la última operación registrada para su ruta
+// tampoco es un cambio nuevo.
+
+// EchoWindow es cuánto tiempo después de una escritura marcada se ignoran
+// los eventos de esa ruta
+const EchoWindow = 3 * time.Second
+
+var (
+	incoming   = make(map[string]time.Time)
+	incomingMu sync.Mutex
+)
+
+// MarkIncoming registra que path acaba de escribirse (o borrarse) por una
+// operación que no es un cambio local
+func MarkIncoming(path string) {
+	key := echoKey(path)
+	incomingMu.Lock()
+	defer incomingMu.Unlock()
+	now := time.Now()
+	incoming[key] = now
+	// Limpiar marcas vencidas para que el mapa no crezca sin límite
+	for k, t := range incoming {
+		if now.Sub(t) > EchoWindow {
+			delete(incoming, k)
+		}
+	}
+}
+
+// IsEcho indica si el estado actual de path lo dejó una operación ya
+// registrada o recibida, y por lo tanto no hay que replicarlo
+func IsEcho(path string) bool {
+	incomingMu.Lock()
+	t, ok := incoming[echoKey(path)]
+	incomingMu.Unlock()
+	if ok && time.Since(t) < EchoWindow {
+		return true
+	}
+
+	op, known := Current(path)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		// Nunca registrado o ya borrado: no hay nada que propagar
+		return !known || op.Type == "DELETE"
+	}
+	if err != nil || !known || op.Type != "TRANSFER" || info.IsDir() {
+		return false
+	}
+	hash, err := fileHash(path, info)
+	return err == nil && hash == op.Hash
+}
+
+// Current retorna la última operación replicada cuyo efecto está en path
+func Current(path string) (log.Operation, bool) {
+	op, ok := currentOps(log.ReadLocalLog())[filepath.Clean(path)]
+	return op, ok
+}
+
+func echoKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
@@ -4,15 +4,39 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+
 	"p2pfs/internal/log"
+	"p2pfs/internal/store"
 )
 
 // ApplyOperation aplica una sola operación (transferencia o eliminación) al FS local.
+// Solo se aplican operaciones con una firma válida de su nodo de origen.
 func ApplyOperation(op log.Operation) error {
+	if err := op.Verify(); err != nil {
+		return fmt.Errorf("operación %s sobre %s rechazada: %w", op.Type, op.Path, err)
+	}
+
 	switch op.Type {
 	case "TRANSFER":
-		// Crear archivo con datos
-		absPath, _ := filepath.Abs(op.Path)
+		target := op.Target()
+
+		// Operaciones nuevas: el contenido está en el almacén de bloques
+		if op.Data == nil {
+			if op.Hash == "" || !store.Complete(op.Hash) {
+				return fmt.Errorf("contenido de %s no disponible", target)
+			}
+			if err := store.Assemble(op.Hash, target); err != nil {
+				return fmt.Errorf("error al reconstruir archivo: %w", err)
+			}
+			MarkIncoming(target)
+			fmt.Printf("📥 Archivo sincronizado: %s\n", target)
+			dropTombstone(op)
+			return nil
+		}
+
+		// Logs antiguos: contenido en línea
+		absPath, _ := filepath.Abs(target)
 		dir := filepath.Dir(absPath)
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			return fmt.Errorf("error creando directorio para archivo: %w", err)
@@ -21,10 +45,15 @@ func ApplyOperation(op log.Operation) error {
 		if err != nil {
 			return fmt.Errorf("error al escribir archivo: %w", err)
 		}
+		MarkIncoming(absPath)
 		fmt.Printf("📥 Archivo sincronizado: %s\n", absPath)
 
 	case "DELETE":
-		return DeletePath(op.Path)
+		if err := DeletePath(op.Path); err != nil {
+			return err
+		}
+		MarkIncoming(op.Path)
+		addTombstone(op)
 
 	default:
 		return fmt.Errorf("operación desconocida: %s", op.Type)
@@ -32,22 +61,149 @@ func ApplyOperation(op log.Operation) error {
 	return nil
 }
 
-// SyncWithLogs recibe una lista de operaciones desde otros nodos
-// y las aplica si son más recientes que el último timestamp local.
-func SyncWithLogs(remoteLogs []log.Operation, lastSync int64) int {
+// Holds indica si este nodo guarda una réplica de path. Con factor de
+// replicación los TRANSFER de rutas ajenas solo se registran, sin traer su
+// contenido; main lo reemplaza por Peer.Holds.
+var Holds = func(path string) bool { return true }
+
+// SyncWithLogs aplica las operaciones recibidas de otros nodos en orden
+// causal (HLC). Una operación que ya es historia de lo que hay en disco, o
+// un TRANSFER anterior a una lápida, solo se registra; una concurrente con
+// lo que hay en disco se resuelve según la política de conflictos (ver
+// conflict.go). Todas quedan en el log para no volver a
+// pedirlas.
+func SyncWithLogs(remoteLogs []log.Operation) int {
+	local := log.ReadLocalLog()
+	history := log.NewHistory(local)
+	current := currentOps(local)
+
+	// Operaciones que ya están en el log local (p. ej. recibidas de otro peer)
+	seen := make(map[string]bool)
+	for _, op := range local {
+		if op.Sig != nil {
+			seen[string(op.Sig)] = true
+		}
+	}
+
+	ops := append([]log.Operation(nil), remoteLogs...)
+	sort.SliceStable(ops, func(i, j int) bool { return ops[i].Before(ops[j]) })
+
 	applied := 0
-	for _, op := range remoteLogs {
-		if op.Time > lastSync {
-			if err := ApplyOperation(op); err == nil {
-				log.AppendToLocalLog(op)
+	for _, op := range ops {
+		if !log.Replicated(op.Type) || (op.Sig != nil && seen[string(op.Sig)]) {
+			continue
+		}
+		if err := op.Verify(); err != nil {
+			fmt.Printf("🔏 Operación %s sobre %s descartada: %v\n", op.Type, op.Path, err)
+			continue
+		}
+
+		target := op.Target()
+		prev, ok := current[target]
+		switch {
+		case buried(history, op):
+			fmt.Printf("🪦 %s fue borrado después de este TRANSFER, no se restaura\n", target)
+		case op.Type == "TRANSFER" && !Holds(target):
+			// Otro nodo es dueño de la ruta: basta con registrarla
+		case !ok || follows(history, prev, op):
+			if err := ApplyOperation(op); err != nil {
+				fmt.Printf("⚠️ %v\n", err)
+				continue
+			}
+			current[target] = op
+			applied++
+		case superseded(history, prev, op) || equivalent(prev, op):
+			fmt.Printf("⏭️ %s sobre %s ya está reflejado localmente, solo se registra\n", op.Type, target)
+		default:
+			winner, err := resolveConflict(prev, op)
+			if err != nil {
+				fmt.Printf("⚠️ Conflicto en %s sin resolver: %v\n", target, err)
+				continue
+			}
+			if winner.ID() == op.ID() {
 				applied++
 			}
+			current[target] = winner
 		}
+
+		log.AppendToLocalLog(op)
+		history.Add(op)
+		seen[string(op.Sig)] = true
 	}
 	fmt.Printf("✅ Sincronización completada. Operaciones aplicadas: %d\n", applied)
 	return applied
 }
 
+// RecordReceived registra el TRANSFER firmado que acompañó a un archivo
+// recibido (si no estaba ya en el log) y descarta la lápida que supera
+func RecordReceived(op log.Operation) {
+	for _, known := range log.ReadLocalLog() {
+		if known.ID() == op.ID() {
+			return
+		}
+	}
+	log.AppendToLocalLog(op)
+	dropTombstone(op)
+}
+
+// currentOps retorna, por ruta, la operación replicada cuyo efecto está en
+// disco: la última según el HLC, sin contar las versiones que quedaron
+// pendientes de un conflicto manual
+func currentOps(ops []log.Operation) map[string]log.Operation {
+	pending := make(map[string]bool)
+	for _, op := range ops {
+		if op.Type == "CONFLICT" && len(op.Parents) == 2 {
+			pending[op.Parents[1]] = true
+		}
+	}
+
+	current := make(map[string]log.Operation)
+	for _, op := range ops {
+		if !log.Replicated(op.Type) || (op.ID() != "" && pending[op.ID()]) {
+			continue
+		}
+		target := op.Target()
+		if prev, ok := current[target]; !ok || prev.Before(op) {
+			current[target] = op
+		}
+	}
+	return current
+}
+
+// CollectStore borra del almacén las versiones que ya no están vivas: las
+// vivas son las de los archivos de shared/, la versión actual de cada ruta
+// según el log y las dos de cada conflicto pendiente. Retorna cuántos
+// manifiestos y bloques se borraron.
+func CollectStore() (int, int) {
+	live := make(map[string]bool)
+	for _, op := range currentOps(log.ReadLocalLog()) {
+		if op.Type == "TRANSFER" && op.Hash != "" {
+			live[op.Hash] = true
+		}
+	}
+	for _, c := range PendingConflicts() {
+		live[c.Local.Hash] = true
+		live[c.Remote.Hash] = true
+	}
+
+	var mark func(node FileNode)
+	mark = func(node FileNode) {
+		if !node.IsDir {
+			live[node.Hash] = true
+		}
+		for _, c := range node.Children {
+			mark(c)
+		}
+	}
+	tree, err := BuildFileTree("shared")
+	if err != nil {
+		// Sin la vista de shared/ no se sabe qué está vivo
+		return 0, 0
+	}
+	mark(tree)
+	return store.Collect(live)
+}
+
 // GetLastSyncTime retorna el timestamp de la última operación local.
 func GetLastSyncTime() int64 {
 	localLog := log.ReadLocalLog()
@@ -0,0 +1,207 @@
+package fs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"p2pfs/internal/config"
+	"p2pfs/internal/log"
+)
+
+// Lápidas: cada DELETE aplicado deja una lápida con la ruta y la versión
+// borrada. Mientras exista, un TRANSFER anterior al borrado (p. ej. la
+// copia vieja de un nodo que estuvo desconectado) no resucita el archivo.
+// Una lápida se descarta recién cuando todos los miembros conocidos
+// confirmaron tener el DELETE.
+
+// Tombstone registra el borrado de una ruta
+type Tombstone struct {
+	Path    string        `json:"path"`
+	Version log.Timestamp `json:"version"` // HLC del DELETE
+	NodeID  string        `json:"node_id"` // Nodo que ordenó el borrado
+	Seq     uint64        `json:"seq"`     // Secuencia del DELETE en el log de NodeID
+	Time    int64         `json:"time"`
+	Acks    []string      `json:"acks,omitempty"` // NodeIDs que ya tienen el DELETE
+}
+
+var tombMu sync.Mutex
+
+func tombstonesFile() string {
+	return config.Path("tombstones.json")
+}
+
+// LoadTombstones lee las lápidas guardadas
+func LoadTombstones() []Tombstone {
+	tombMu.Lock()
+	defer tombMu.Unlock()
+	return loadTombstones()
+}
+
+func loadTombstones() []Tombstone {
+	var tombs []Tombstone
+	data, err := os.ReadFile(tombstonesFile())
+	if err != nil {
+		return tombs
+	}
+	if err := json.Unmarshal(data, &tombs); err != nil {
+		fmt.Printf("⚠️ Lápidas con formato inválido: %v\n", err)
+	}
+	return tombs
+}
+
+func saveTombstones(tombs []Tombstone) error {
+	if err := os.MkdirAll(filepath.Dir(tombstonesFile()), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(tombs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(tombstonesFile(), data, 0644)
+}
+
+// updateTombstones aplica fn a las lápidas y guarda el resultado
+func updateTombstones(fn func([]Tombstone) []Tombstone) {
+	tombMu.Lock()
+	defer tombMu.Unlock()
+	if err := saveTombstones(fn(loadTombstones())); err != nil {
+		fmt.Printf("⚠️ Error al guardar lápidas: %v\n", err)
+	}
+}
+
+// addTombstone registra el borrado de op. Si ya había una lápida para la
+// ruta se queda la más reciente.
+func addTombstone(op log.Operation) {
+	if op.ID() == "" {
+		return
+	}
+	t := Tombstone{
+		Path:    op.Target(),
+		Version: op.Stamp(),
+		NodeID:  op.NodeID,
+		Seq:     op.Seq,
+		Time:    op.Time,
+	}
+	updateTombstones(func(tombs []Tombstone) []Tombstone {
+		for i := range tombs {
+			if tombs[i].Path == t.Path {
+				if tombs[i].Version.Before(t.Version) {
+					tombs[i] = t
+				}
+				return tombs
+			}
+		}
+		return append(tombs, t)
+	})
+}
+
+// dropTombstone descarta la lápida de path si el TRANSFER op es posterior
+// al borrado (la ruta vuelve a existir)
+func dropTombstone(op log.Operation) {
+	updateTombstones(func(tombs []Tombstone) []Tombstone {
+		kept := tombs[:0]
+		for _, t := range tombs {
+			if t.Path == op.Target() && t.Version.Before(op.Stamp()) {
+				continue
+			}
+			kept = append(kept, t)
+		}
+		return kept
+	})
+}
+
+// TombstoneFor retorna la lápida de path, si la hay
+func TombstoneFor(path string) (Tombstone, bool) {
+	path = filepath.Clean(path)
+	for _, t := range LoadTombstones() {
+		if t.Path == path {
+			return t, true
+		}
+	}
+	return Tombstone{}, false
+}
+
+// buried indica si un TRANSFER es anterior al borrado de su ruta: ni
+// desciende del DELETE ni es posterior según el HLC
+func buried(h log.History, op log.Operation) bool {
+	if op.Type != "TRANSFER" {
+		return false
+	}
+	t, ok := TombstoneFor(op.Target())
+	if !ok {
+		return false
+	}
+	if op.ID() != "" && h.Descends(op, fmt.Sprintf("%s:%d", t.NodeID, t.Seq)) {
+		return false
+	}
+	return !t.Version.Before(op.Stamp())
+}
+
+// Revives indica si el TRANSFER op puede volver a crear su ruta: sin
+// lápida siempre; con lápida, solo si desciende del DELETE, es decir si su
+// origen ya sabía del borrado. Un HLC posterior no alcanza: una copia
+// vieja reenviada después del borrado también lo tiene.
+func Revives(op log.Operation) bool {
+	t, ok := TombstoneFor(op.Target())
+	if !ok {
+		return true
+	}
+	h := log.NewHistory(log.ReadLocalLog())
+	return op.ID() != "" && h.Descends(op, fmt.Sprintf("%s:%d", t.NodeID, t.Seq))
+}
+
+// AckTombstones registra que nodeID tiene las operaciones hasta cursors
+// (ver log.Cursors): las lápidas cuyo DELETE está cubierto quedan
+// confirmadas por ese nodo
+func AckTombstones(nodeID string, cursors map[string]uint64) {
+	if nodeID == "" || len(cursors) == 0 {
+		return
+	}
+	updateTombstones(func(tombs []Tombstone) []Tombstone {
+		for i := range tombs {
+			t := &tombs[i]
+			if cursors[t.NodeID] >= t.Seq && !contains(t.Acks, nodeID) {
+				t.Acks = append(t.Acks, nodeID)
+			}
+		}
+		return tombs
+	})
+}
+
+// CollectTombstones descarta las lápidas confirmadas por todos los
+// members (NodeIDs de los miembros conocidos, sin contar este nodo) y
+// retorna cuántas se descartaron
+func CollectTombstones(members []string) int {
+	collected := 0
+	updateTombstones(func(tombs []Tombstone) []Tombstone {
+		kept := tombs[:0]
+		for _, t := range tombs {
+			done := true
+			for _, m := range members {
+				if m != t.NodeID && !contains(t.Acks, m) {
+					done = false
+					break
+				}
+			}
+			if done {
+				collected++
+				continue
+			}
+			kept = append(kept, t)
+		}
+		return kept
+	})
+	return collected
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
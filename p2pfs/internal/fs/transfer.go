@@ -1,6 +1,7 @@
 package fs
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -26,13 +27,15 @@ func SaveFile(path string, data []byte) error {
 		return fmt.Errorf("error escribiendo archivo: %w", err)
 	}
 
+	MarkIncoming(absPath)
 	fmt.Printf("📁 Archivo guardado: %s\n", absPath)
 
-	// Registrar operación en log
+	// Registrar operación en log (solo el hash, no el contenido)
 	op := log.Operation{
 		Type: "TRANSFER",
 		Path: absPath,
-		Data: data,
+		Hash: fmt.Sprintf("%x", sha256.Sum256(data)),
+		Size: int64(len(data)),
 		Time: time.Now().Unix(),
 	}
 	log.AppendToLocalLog(op)
@@ -1,16 +1,26 @@
 package fs
 
 import (
+	"crypto/sha256"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 )
 
-// FileNode representa un nodo en el árbol de archivos.
+// FileNode representa un nodo en el árbol de archivos. Con los hashes el
+// árbol es un árbol de Merkle: el de un archivo es el SHA-256 de su
+// contenido y el de una carpeta resume los nombres y hashes de sus hijos,
+// así que dos carpetas con el mismo hash tienen el mismo contenido.
 type FileNode struct {
 	Name     string     `json:"name"`               // Nombre del archivo o carpeta
 	IsDir    bool       `json:"is_dir"`             // Si es directorio
 	ModTime  time.Time  `json:"mod_time"`           // Última modificación
+	Size     int64      `json:"size,omitempty"`     // Tamaño en bytes (archivos)
+	Hash     string     `json:"hash,omitempty"`     // Hash de Merkle
 	Children []FileNode `json:"children,omitempty"` // Hijos (si es directorio)
 }
 
@@ -28,7 +38,9 @@ func BuildFileTree(root string) (FileNode, error) {
 	}
 
 	if !info.IsDir() {
-		return node, nil
+		node.Size = info.Size()
+		node.Hash, err = fileHash(root, info)
+		return node, err
 	}
 
 	entries, err := os.ReadDir(root)
@@ -44,6 +56,76 @@ func BuildFileTree(root string) (FileNode, error) {
 		}
 	}
 
+	node.Hash = dirHash(node.Children)
+	return node, nil
+}
+
+// Subtree retorna el nodo de rel (relativo a root) con sus hijos directos,
+// sin los nietos: es lo que se intercambia al descender por el árbol de
+// Merkle
+func Subtree(root, rel string) (FileNode, error) {
+	rel = filepath.Clean(rel)
+	if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return FileNode{}, fmt.Errorf("ruta fuera de %s: %s", root, rel)
+	}
+	node, err := BuildFileTree(filepath.Join(root, rel))
+	if err != nil {
+		return node, err
+	}
+	for i := range node.Children {
+		node.Children[i].Children = nil
+	}
 	return node, nil
 }
 
+// dirHash combina los hijos (ya ordenados por nombre) en el hash de la carpeta
+func dirHash(children []FileNode) string {
+	h := sha256.New()
+	for _, c := range children {
+		kind := "f"
+		if c.IsDir {
+			kind = "d"
+		}
+		fmt.Fprintf(h, "%s %s %s\n", kind, c.Name, c.Hash)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// cachedHash es el hash de un archivo mientras no cambien tamaño ni fecha
+type cachedHash struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+var (
+	hashCache   = make(map[string]cachedHash)
+	hashCacheMu sync.Mutex
+)
+
+// fileHash retorna el SHA-256 del archivo path, sin releerlo si no cambió
+// desde la última vez
+func fileHash(path string, info os.FileInfo) (string, error) {
+	hashCacheMu.Lock()
+	c, ok := hashCache[path]
+	hashCacheMu.Unlock()
+	if ok && c.size == info.Size() && c.modTime.Equal(info.ModTime()) {
+		return c.hash, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sum := fmt.Sprintf("%x", h.Sum(nil))
+
+	hashCacheMu.Lock()
+	hashCache[path] = cachedHash{size: info.Size(), modTime: info.ModTime(), hash: sum}
+	hashCacheMu.Unlock()
+	return sum, nil
+}
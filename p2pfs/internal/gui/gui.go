@@ -3,14 +3,13 @@ package gui
 import (
 	"fmt"
 	"image/color"
-	"net"
-	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"p2pfs/internal/fs"
+	"p2pfs/internal/log"
 	"p2pfs/internal/peer"
 
 	"fyne.io/fyne/v2"
@@ -37,10 +36,10 @@ var selectedColor = color.RGBA{R: 0, G: 122, B: 255, A: 255}       // #007AFF
 var textPrimary = color.RGBA{R: 238, G: 238, B: 238, A: 255}       // #EEEEEE
 var textSecondary = color.RGBA{R: 200, G: 200, B: 200, A: 255}     // gris claro para fechas
 
-func StartGUI(selfID int, peers []peer.PeerInfo, self *peer.Peer) {
+func StartGUI(selfID int, self *peer.Peer) {
 	conn = self
 	fileButtons = make(map[string]*widget.Button)
-	peersList = peers
+	peersList = self.Peers()
 	selfPort = self.Port
 
 	a := app.New()
@@ -63,14 +62,16 @@ func StartGUI(selfID int, peers []peer.PeerInfo, self *peer.Peer) {
 			dialog.ShowInformation("Aviso", "No hay archivo seleccionado", w)
 			return
 		}
-		err := os.Remove("shared/" + selectedFile)
+		results, err := conn.DeleteFile("shared/" + selectedFile)
 		if err != nil {
 			dialog.ShowError(err, w)
-		} else {
-			updateLocalFiles()
-			statusLabel.SetText("🗑️ Archivo eliminado: " + selectedFile)
-			selectedFile = ""
+			return
 		}
+		msg, success := resultsSummary(results, "Eliminado")
+		dialog.ShowInformation("Eliminación", msg, w)
+		updateLocalFiles()
+		statusLabel.SetText(fmt.Sprintf("🗑️ Archivo eliminado: %s (avisado a %d nodo(s))", selectedFile, success))
+		selectedFile = ""
 	})
 
 	transferBtn := widget.NewButton("Transferir archivo", func() {
@@ -78,49 +79,61 @@ func StartGUI(selfID int, peers []peer.PeerInfo, self *peer.Peer) {
 			dialog.ShowInformation("Aviso", "Seleccione un archivo primero", w)
 			return
 		}
-		msg := ""
-		success := 0
-		for _, peerInfo := range peersList {
-			if peerInfo.Port == selfPort {
-				continue
-			}
-			addr := fmt.Sprintf("%s:%s", peerInfo.IP, peerInfo.Port)
-			err := conn.SendFile("shared/"+selectedFile, addr)
-			if err != nil {
-				msg += fmt.Sprintf("❌ %s: %v\n", addr, err)
-			} else {
-				msg += fmt.Sprintf("✅ %s: Enviado\n", addr)
-				success++
-			}
+		// Solo cuenta como enviado lo que la réplica confirmó con el hash correcto
+		results, err := conn.QuorumWrite("shared/" + selectedFile)
+		if results == nil && err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		msg, success := resultsSummary(results, "Enviado y verificado")
+		if err != nil {
+			msg += fmt.Sprintf("\n⚠️ %v", err)
+			statusLabel.SetText(fmt.Sprintf("⚠️ Archivo verificado en %d nodo(s), sin quórum", success))
+		} else {
+			statusLabel.SetText(fmt.Sprintf("📤 Archivo enviado y verificado en %d nodo(s)", success))
 		}
 		dialog.ShowInformation("Transferencia", msg, w)
-		statusLabel.SetText(fmt.Sprintf("📤 Archivo enviado a %d nodo(s)", success))
 	})
 
-	buttonBar := container.NewHBox(updateBtn, deleteBtn, transferBtn)
+	versionBtn := widget.NewButton("Ver versión", func() {
+		if selectedFile == "" {
+			dialog.ShowInformation("Aviso", "Seleccione un archivo primero", w)
+			return
+		}
+		v, err := conn.QuorumStat("shared/" + selectedFile)
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		dialog.ShowInformation("Versión", versionSummary(selectedFile, v), w)
+		updateLocalFiles()
+	})
+
+	// Conflictos pendientes (política manual): elegir qué versión queda
+	conflictsBtn := widget.NewButton("Conflictos", func() {
+		showConflicts(w, statusLabel)
+	})
+
+	buttonBar := container.NewHBox(updateBtn, deleteBtn, transferBtn, versionBtn, conflictsBtn)
 
 	for _, p := range peersList {
 		isLocal := p.ID == selfID
-		titleText := ""
-		if isLocal {
-
-			tree, _ := conn.RequestFileTree(fmt.Sprintf("%s:%s", p.IP, p.Port))
-      var fileRows []fyne.CanvasObject
-      if tree != nil {
-
-	      fileRows = buildTreeUI(*tree, 0)
-    } else {
-	    fileRows = []fyne.CanvasObject{canvas.NewText("[!] Sin conexión", textSecondary)}
-    }
-		} else {
-			titleText = fmt.Sprintf("Máquina %d (%s:%s)", p.ID, p.IP, p.Port)
-		}
-		iconStatus := widget.NewIcon(theme.CancelIcon())
-		connTest, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%s", p.IP, p.Port), 500*time.Millisecond)
-		if err == nil {
-			iconStatus = widget.NewIcon(theme.ConfirmIcon())
-			connTest.Close()
+		titleText := fmt.Sprintf("Máquina %d (%s:%s)", p.ID, p.IP, p.Port)
+		// Estado según la vista de membresía compartida
+		alive := isLocal || conn.IsAliveAt(p, phiOffline)
+		var remoteRows []fyne.CanvasObject
+		if !isLocal {
+			var tree *fs.FileNode
+			if alive {
+				tree, _ = conn.RequestFileTree(fmt.Sprintf("%s:%s", p.IP, p.Port))
+			}
+			if tree != nil {
+				remoteRows = buildTreeUI(*tree, 0)
+			} else {
+				remoteRows = []fyne.CanvasObject{canvas.NewText("[!] Sin conexión", textSecondary)}
+			}
 		}
+		iconStatus := widget.NewIcon(statusIcon(isLocal, conn.Phi(p)))
 		title := container.NewCenter(
 			container.NewHBox(
 				canvas.NewText(titleText, textPrimary),
@@ -170,6 +183,9 @@ func StartGUI(selfID int, peers []peer.PeerInfo, self *peer.Peer) {
 			}(name)
 			fileRows = append(fileRows, container.NewHBox(icon, btn, modTime))
 		}
+		if !isLocal {
+			fileRows = remoteRows
+		}
 		fileList := container.NewVBox(fileRows...)
 		if isLocal {
 			localFileListWidget = fileList
@@ -231,6 +247,108 @@ func updateLocalFiles() {
 	localFileListWidget.Refresh()
 }
 
+// resultsSummary arma el detalle por peer de un envío al clúster y cuenta
+// las entregas exitosas
+func resultsSummary(results []peer.TransferResult, done string) (string, int) {
+	msg := ""
+	success := 0
+	for _, r := range results {
+		addr := fmt.Sprintf("%s:%s", r.Peer.IP, r.Peer.Port)
+		switch {
+		case r.Err == nil:
+			msg += fmt.Sprintf("✅ %s: %s\n", addr, done)
+			success++
+		case r.Queued:
+			msg += fmt.Sprintf("⏳ %s: %v (en cola de reintentos)\n", addr, r.Err)
+		default:
+			msg += fmt.Sprintf("❌ %s: %v\n", addr, r.Err)
+		}
+	}
+	if msg == "" {
+		msg = "No hay otros nodos conocidos"
+	}
+	return msg, success
+}
+
+// showConflicts lista los conflictos pendientes de resolución manual y
+// resuelve el elegido con fs.ResolveConflict, que lo registra en el oplog
+func showConflicts(w fyne.Window, statusLabel *widget.Label) {
+	pending := fs.PendingConflicts()
+	if len(pending) == 0 {
+		dialog.ShowInformation("Conflictos", "No hay conflictos pendientes", w)
+		return
+	}
+
+	var d dialog.Dialog
+	rows := []fyne.CanvasObject{}
+	for _, c := range pending {
+		c := c
+		rows = append(rows, widget.NewLabel(fmt.Sprintf("⚠️ %s", filepath.Base(c.Path))))
+		for _, v := range []struct {
+			label string
+			op    log.Operation
+		}{{"local", c.Local}, {"remota", c.Remote}} {
+			op := v.op
+			text := fmt.Sprintf("Conservar versión %s: %s de %s", v.label, op.Type, shortID(op.NodeID))
+			if op.Type == "TRANSFER" {
+				text += fmt.Sprintf(" (%d bytes)", op.Size)
+			}
+			rows = append(rows, widget.NewButton(text, func() {
+				d.Hide()
+				if err := fs.ResolveConflict(c.Path, op.ID()); err != nil {
+					dialog.ShowError(err, w)
+					return
+				}
+				updateLocalFiles()
+				statusLabel.SetText(fmt.Sprintf("✅ Conflicto en %s resuelto", filepath.Base(c.Path)))
+			}))
+		}
+	}
+	d = dialog.NewCustom("Conflictos pendientes", "Cerrar", container.NewVScroll(container.NewVBox(rows...)), w)
+	d.Resize(fyne.NewSize(600, 400))
+	d.Show()
+}
+
+// shortID acorta un NodeID para mostrarlo
+func shortID(nodeID string) string {
+	if len(nodeID) > 8 {
+		return nodeID[:8]
+	}
+	return nodeID
+}
+
+// versionSummary describe la versión más nueva de un archivo según el quórum
+func versionSummary(name string, v peer.FileVersion) string {
+	if !v.Exists {
+		return fmt.Sprintf("🗑️ %s fue eliminado (según nodo %d)", name, v.Peer.ID)
+	}
+	msg := fmt.Sprintf("📄 %s\nTamaño: %d bytes\nModificado: %s\nSHA-256: %s\nVersión más nueva en: nodo %d",
+		name, v.Size, v.Mod.Format("02/01/2006 15:04"), v.Hash, v.Peer.ID)
+	if v.Op != nil {
+		msg += fmt.Sprintf("\nÚltima operación: %s %s", v.Op.Type, v.Op.ID())
+	}
+	return msg
+}
+
+// Umbrales de φ del ícono de estado: la GUI avisa antes de lo que la
+// membresía tarda en dar a un nodo por caído
+const (
+	phiWarning = 3.0
+	phiOffline = peer.DefaultPhiThreshold
+)
+
+// statusIcon elige el ícono de estado de un panel según la sospecha φ
+func statusIcon(isLocal bool, phi float64) fyne.Resource {
+	switch {
+	case isLocal || phi < phiWarning:
+		return theme.ConfirmIcon()
+	case phi < phiOffline:
+		return theme.WarningIcon()
+	default:
+		return theme.CancelIcon()
+	}
+}
+
 func iconoPorNombre(nombre string) fyne.Resource {
 	ext := strings.ToLower(filepath.Ext(nombre))
 	switch ext {
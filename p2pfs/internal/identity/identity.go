@@ -0,0 +1,135 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"p2pfs/internal/config"
+)
+
+// Identidad criptográfica del nodo: un par Ed25519 persistente. El NodeID
+// se deriva de la clave pública, así que cualquiera puede comprobar que una
+// firma pertenece al nodo que dice haberla hecho sin una autoridad central.
+
+var (
+	ErrUnsigned     = errors.New("sin firma")
+	ErrBadSignature = errors.New("firma inválida")
+	ErrNodeMismatch = errors.New("el NodeID no corresponde a la clave pública")
+)
+
+// Identity es el par de claves de un nodo
+type Identity struct {
+	NodeID  string
+	Public  ed25519.PublicKey
+	private ed25519.PrivateKey
+}
+
+// Current es la identidad del nodo local. Arranca con una clave efímera;
+// main la reemplaza con la persistente de Load.
+var Current = mustGenerate()
+
+// DefaultFile es donde se guarda la clave privada del nodo
+func DefaultFile() string {
+	return config.Path("identity.key")
+}
+
+// NodeIDFor deriva el NodeID de una clave pública (16 hex de su SHA-256)
+func NodeIDFor(pub []byte) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Load lee la clave de path o crea una nueva si no existe
+func Load(path string) (*Identity, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		id, err := generate()
+		if err != nil {
+			return nil, err
+		}
+		if err := id.save(path); err != nil {
+			return nil, err
+		}
+		return id, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s no contiene una clave PEM", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("clave inválida en %s: %w", path, err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s no es una clave Ed25519", path)
+	}
+	return fromPrivate(priv), nil
+}
+
+// Sign firma data con la clave del nodo
+func (id *Identity) Sign(data []byte) []byte {
+	return ed25519.Sign(id.private, data)
+}
+
+// Verify comprueba que sig sea una firma de data hecha por el nodo nodeID
+// con la clave pub
+func Verify(nodeID string, pub, data, sig []byte) error {
+	if len(sig) == 0 {
+		return ErrUnsigned
+	}
+	if len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w: clave pública de %d bytes", ErrBadSignature, len(pub))
+	}
+	if NodeIDFor(pub) != nodeID {
+		return ErrNodeMismatch
+	}
+	if !ed25519.Verify(pub, data, sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+func generate() (*Identity, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return fromPrivate(priv), nil
+}
+
+func mustGenerate() *Identity {
+	id, err := generate()
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+func fromPrivate(priv ed25519.PrivateKey) *Identity {
+	pub := priv.Public().(ed25519.PublicKey)
+	return &Identity{NodeID: NodeIDFor(pub), Public: pub, private: priv}
+}
+
+func (id *Identity) save(path string) error {
+	der, err := x509.MarshalPKCS8PrivateKey(id.private)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+}
@@ -0,0 +1,53 @@
+package log
+
+import "sort"
+
+// Cursores de sincronización: cada nodo numera sus operaciones (Seq) y un
+// peer sabe hasta dónde tiene el log de cada origen. Un SYNC_REQUEST lleva
+// esos cursores y el que responde envía solo lo posterior.
+
+// Cursors retorna, por NodeID de origen, la mayor Seq tal que el log local
+// tiene todas las operaciones 1..Seq de ese nodo. Un hueco detiene el
+// cursor para que lo que falta se vuelva a pedir.
+func Cursors() map[string]uint64 {
+	seqs := make(map[string][]uint64)
+	for _, op := range ReadLocalLog() {
+		if op.Seq > 0 && op.NodeID != "" {
+			seqs[op.NodeID] = append(seqs[op.NodeID], op.Seq)
+		}
+	}
+
+	cursors := make(map[string]uint64, len(seqs))
+	for node, list := range seqs {
+		sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+		var c uint64
+		for _, seq := range list {
+			if seq == c+1 {
+				c = seq
+			} else if seq > c+1 {
+				break
+			}
+		}
+		cursors[node] = c
+	}
+	return cursors
+}
+
+// OpsAfter retorna las operaciones posteriores a los cursores, ordenadas
+// por origen y secuencia. Las operaciones sin Seq (logs anteriores) no se
+// sincronizan por cursor.
+func OpsAfter(cursors map[string]uint64) []Operation {
+	var ops []Operation
+	for _, op := range ReadLocalLog() {
+		if op.Seq > 0 && op.Seq > cursors[op.NodeID] {
+			ops = append(ops, op)
+		}
+	}
+	sort.SliceStable(ops, func(i, j int) bool {
+		if ops[i].NodeID != ops[j].NodeID {
+			return ops[i].NodeID < ops[j].NodeID
+		}
+		return ops[i].Seq < ops[j].Seq
+	})
+	return ops
+}
@@ -0,0 +1,93 @@
+package log
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Historia causal por ruta: cada operación replicada lista en Parents las
+// operaciones sobre su misma ruta que su origen ya conocía (las "cabezas").
+// Si una operación no desciende de la que otro nodo aplicó, ambas se
+// hicieron sin verse: hay un conflicto.
+
+// ID identifica una operación replicada en todo el clúster (NodeID:Seq).
+// Las operaciones sin secuencia no tienen ID.
+func (op Operation) ID() string {
+	if op.Seq == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", op.NodeID, op.Seq)
+}
+
+// Target retorna la ruta sobre la que actúa la operación
+func (op Operation) Target() string {
+	if op.Path == "" && op.FileName != "" {
+		return filepath.Join("shared", op.FileName)
+	}
+	return filepath.Clean(op.Path)
+}
+
+// History indexa por ID las operaciones replicadas para recorrer su historia
+type History map[string]Operation
+
+// NewHistory arma la historia de ops
+func NewHistory(ops []Operation) History {
+	h := make(History)
+	for _, op := range ops {
+		h.Add(op)
+	}
+	return h
+}
+
+// Add incorpora op a la historia
+func (h History) Add(op Operation) {
+	if Replicated(op.Type) && op.ID() != "" {
+		h[op.ID()] = op
+	}
+}
+
+// Descends indica si op desciende de la operación con ID ancestor, es decir
+// si su origen ya la conocía al registrarla
+func (h History) Descends(op Operation, ancestor string) bool {
+	visited := make(map[string]bool)
+	pending := append([]string(nil), op.Parents...)
+	for len(pending) > 0 {
+		id := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if id == ancestor {
+			return true
+		}
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+		if parent, ok := h[id]; ok {
+			pending = append(pending, parent.Parents...)
+		}
+	}
+	return false
+}
+
+// heads retorna los IDs de las operaciones sobre target de las que no
+// desciende ninguna otra del log
+func heads(ops []Operation, target string) []string {
+	var ids []string
+	covered := make(map[string]bool)
+	for _, op := range ops {
+		if !Replicated(op.Type) || op.ID() == "" || op.Target() != target {
+			continue
+		}
+		ids = append(ids, op.ID())
+		for _, parent := range op.Parents {
+			covered[parent] = true
+		}
+	}
+
+	var out []string
+	for _, id := range ids {
+		if !covered[id] {
+			out = append(out, id)
+		}
+	}
+	return out
+}
@@ -0,0 +1,99 @@
+package log
+
+import (
+	"sync"
+	"time"
+)
+
+// Reloj lógico híbrido (HLC, Kulkarni et al.): cada operación lleva una
+// marca que nunca retrocede y que siempre supera a las de las operaciones
+// que el nodo ya había visto, aunque los relojes de las máquinas del
+// laboratorio no estén sincronizados. Así una operación de un nodo con el
+// reloj atrasado no queda "en el pasado" y las operaciones se pueden
+// ordenar de forma causal entre nodos.
+
+// Timestamp es una marca del reloj lógico híbrido
+type Timestamp struct {
+	Wall    int64  // Milisegundos Unix: el mayor reloj físico conocido
+	Logical uint32 // Desempate entre eventos con el mismo Wall
+}
+
+// Before indica si t es anterior a u
+func (t Timestamp) Before(u Timestamp) bool {
+	if t.Wall != u.Wall {
+		return t.Wall < u.Wall
+	}
+	return t.Logical < u.Logical
+}
+
+// hlc es el reloj del nodo local
+type hlc struct {
+	mu     sync.Mutex
+	last   Timestamp
+	seeded bool // Ya se tuvo en cuenta el log guardado
+}
+
+var clock hlc
+
+// now retorna una marca nueva, posterior a todas las emitidas u observadas
+func (c *hlc) now() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	phys := time.Now().UnixMilli()
+	if phys > c.last.Wall {
+		c.last = Timestamp{Wall: phys}
+	} else {
+		c.last.Logical++
+	}
+	return c.last
+}
+
+// observe adelanta el reloj para que las próximas marcas superen a ts
+func (c *hlc) observe(ts Timestamp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.last.Before(ts) {
+		c.last = ts
+	}
+}
+
+// seed tiene en cuenta las marcas del log guardado, para no retroceder
+// tras un reinicio; solo actúa la primera vez
+func (c *hlc) seed(ops []Operation) {
+	c.mu.Lock()
+	seeded := c.seeded
+	c.seeded = true
+	c.mu.Unlock()
+
+	if seeded {
+		return
+	}
+	for _, op := range ops {
+		c.observe(op.Stamp())
+	}
+}
+
+// Stamp retorna la marca HLC de la operación. Las operaciones de logs
+// anteriores al HLC usan su marca de tiempo Unix.
+func (op Operation) Stamp() Timestamp {
+	if op.Clock.Wall == 0 {
+		return Timestamp{Wall: op.Time * 1000}
+	}
+	return op.Clock
+}
+
+// Before indica si op va antes que other en el orden causal. Dos
+// operaciones con la misma marca se ordenan por NodeID y secuencia para
+// que todos los nodos lleguen al mismo orden.
+func (op Operation) Before(other Operation) bool {
+	a, b := op.Stamp(), other.Stamp()
+	if a != b {
+		return a.Before(b)
+	}
+	if op.NodeID != other.NodeID {
+		return op.NodeID < other.NodeID
+	}
+	return op.Seq < other.Seq
+}
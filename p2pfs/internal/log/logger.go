@@ -6,30 +6,72 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+
+	"p2pfs/internal/identity"
 )
 
 var logFile = "log/oplog.json"
 var mu sync.Mutex // para acceso concurrente seguro
 
-// AppendToLocalLog agrega una operación al registro local
-func AppendToLocalLog(op Operation) {
+// AppendToLocalLog agrega una operación al registro local y la retorna tal
+// como quedó guardada. Las operaciones propias reciben aquí su marca HLC y
+// su firma y, si se replican (TRANSFER, DELETE), su número de secuencia y
+// sus Parents (si el llamador no los fijó); las recibidas conservan los de
+// su origen y adelantan el reloj local.
+func AppendToLocalLog(op Operation) Operation {
 	mu.Lock()
 	defer mu.Unlock()
 
-	ops := ReadLocalLog()
+	ops := readLocalLog()
 
+	clock.seed(ops)
+	if op.Sig == nil {
+		if Replicated(op.Type) {
+			op.Seq = nextSeq(ops)
+			if op.Parents == nil {
+				op.Parents = heads(ops, op.Target())
+			}
+		}
+		op.Clock = clock.now()
+		op.Sign()
+	} else {
+		clock.observe(op.Stamp())
+	}
 	ops = append(ops, op)
 
 	if err := saveLogToFile(ops); err != nil {
 		fmt.Printf("⚠️ Error al guardar log: %v\n", err)
 	}
+	return op
+}
+
+// Replicated indica si las operaciones de tipo t se propagan por SYNC; el
+// resto (HASH_OK, SEND_FAIL, ...) son eventos locales
+func Replicated(t string) bool {
+	return t == "TRANSFER" || t == "DELETE"
+}
+
+// nextSeq retorna la siguiente secuencia para una operación propia
+func nextSeq(ops []Operation) uint64 {
+	self := identity.Current.NodeID
+	var max uint64
+	for _, op := range ops {
+		if op.NodeID == self && op.Seq > max {
+			max = op.Seq
+		}
+	}
+	return max + 1
 }
 
 // ReadLocalLog devuelve todas las operaciones registradas localmente
 func ReadLocalLog() []Operation {
 	mu.Lock()
 	defer mu.Unlock()
+	return readLocalLog()
+}
 
+// readLocalLog lee el archivo de log; el llamador debe tener mu
+func readLocalLog() []Operation {
 	var ops []Operation
 
 	data, err := os.ReadFile(logFile)
@@ -3,8 +3,21 @@ package log
 // Operation representa una acción sobre el sistema de archivos distribuido.
 // Es usada para sincronización y registro de cambios.
 type Operation struct {
-	Type string // "TRANSFER" o "DELETE"
-	Path string // Ruta relativa o absoluta del archivo o carpeta
-	Data []byte // Contenido binario del archivo (solo para TRANSFER)
-	Time int64  // Marca de tiempo Unix (para orden cronológico)
+	Type     string    // "TRANSFER", "DELETE", "HASH_OK", "HASH_FAIL", "SEND_OK", "SEND_FAIL", ...
+	Path     string    // Ruta relativa o absoluta del archivo o carpeta
+	FileName string    // Nombre del archivo transferido (eventos de red)
+	From     string    // Dirección IP:puerto del otro extremo
+	Hash     string    // SHA-256 del contenido (TRANSFER)
+	Size     int64     // Tamaño del contenido en bytes (TRANSFER)
+	Data     []byte    // Contenido en línea; obsoleto, solo en logs antiguos
+	Time     int64     // Marca de tiempo Unix (para orden cronológico)
+	Message  string    // Detalle legible del evento
+	Seq      uint64    // Número de secuencia en el log del nodo de origen (cursores de SYNC)
+	Clock    Timestamp // Reloj lógico híbrido del origen; ordena entre nodos
+	Parents  []string  // IDs de las operaciones sobre la ruta que el origen ya conocía
+
+	// Firma del nodo que originó la operación (ver Sign/Verify)
+	NodeID string // NodeID del firmante, derivado de PubKey
+	PubKey []byte // Clave pública Ed25519 del firmante
+	Sig    []byte // Firma Ed25519 de la operación sin este campo
 }
@@ -0,0 +1,27 @@
+package log
+
+import (
+	"encoding/json"
+
+	"p2pfs/internal/identity"
+)
+
+// Sign firma la operación con la identidad del nodo local
+func (op *Operation) Sign() {
+	id := identity.Current
+	op.NodeID = id.NodeID
+	op.PubKey = id.Public
+	op.Sig = id.Sign(op.signedBytes())
+}
+
+// Verify comprueba que la operación esté firmada por el nodo que indica
+func (op Operation) Verify() error {
+	return identity.Verify(op.NodeID, op.PubKey, op.signedBytes(), op.Sig)
+}
+
+// signedBytes es la serialización que se firma: todo menos Sig
+func (op Operation) signedBytes() []byte {
+	op.Sig = nil
+	data, _ := json.Marshal(op)
+	return data
+}
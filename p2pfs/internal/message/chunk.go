@@ -0,0 +1,75 @@
+package message
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultChunkSize es el tamaño de bloque usado al transmitir archivos.
+const DefaultChunkSize = 1 << 20
+
+// chunkHeaderSize = índice (4 B) + SHA-256 del bloque (32 B)
+const chunkHeaderSize = 4 + sha256.Size
+
+var ErrChunkChecksum = errors.New("checksum de bloque inválido")
+
+// Chunk es un bloque de un archivo enviado tras una trama TRANSFER.
+// Cada bloque lleva su propio SHA-256 para detectar corrupción sin
+// esperar a recibir el archivo completo.
+type Chunk struct {
+	Index uint32
+	Sum   [sha256.Size]byte
+	Data  []byte
+}
+
+// NewChunk crea un bloque calculando su checksum
+func NewChunk(index uint32, data []byte) Chunk {
+	return Chunk{
+		Index: index,
+		Sum:   sha256.Sum256(data),
+		Data:  data,
+	}
+}
+
+// Valid comprueba que el contenido coincide con el checksum
+func (c Chunk) Valid() bool {
+	return sha256.Sum256(c.Data) == c.Sum
+}
+
+// WriteChunk envía un bloque en una trama CHUNK
+func WriteChunk(w io.Writer, c Chunk) error {
+	var buf bytes.Buffer
+	buf.Grow(chunkHeaderSize + len(c.Data))
+	binary.Write(&buf, binary.BigEndian, c.Index)
+	buf.Write(c.Sum[:])
+	buf.Write(c.Data)
+	return WriteFrame(w, FrameChunk, buf.Bytes())
+}
+
+// ReadChunk lee una trama CHUNK y verifica su checksum
+func ReadChunk(r io.Reader) (Chunk, error) {
+	frame, err := ReadFrame(r)
+	if err != nil {
+		return Chunk{}, err
+	}
+	if frame.Type != FrameChunk {
+		return Chunk{}, fmt.Errorf("se esperaba CHUNK, llegó %s", frame.Type)
+	}
+	if len(frame.Payload) < chunkHeaderSize {
+		return Chunk{}, fmt.Errorf("trama CHUNK truncada")
+	}
+
+	var c Chunk
+	c.Index = binary.BigEndian.Uint32(frame.Payload[0:4])
+	copy(c.Sum[:], frame.Payload[4:chunkHeaderSize])
+	c.Data = frame.Payload[chunkHeaderSize:]
+
+	if !c.Valid() {
+		return c, fmt.Errorf("%w (bloque %d)", ErrChunkChecksum, c.Index)
+	}
+	return c, nil
+}
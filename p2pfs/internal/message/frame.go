@@ -0,0 +1,212 @@
+package message
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Formato de una trama en el cable (enteros en big endian):
+//
+//	+--------+---------+------+----------+---------+
+//	| magic  | versión | tipo | longitud | payload |
+//	| 4 B    | 1 B     | 1 B  | 4 B      | N B     |
+//	+--------+---------+------+----------+---------+
+//
+// Todo el tráfico TCP entre nodos (archivos, borrados, sincronización,
+// listados) viaja en tramas con este formato sobre un único puerto.
+
+// Magic identifica las tramas del protocolo P2PFS.
+var Magic = [4]byte{'P', '2', 'P', 'F'}
+
+// ProtocolVersion es la versión del protocolo que habla este nodo.
+const ProtocolVersion uint8 = 1
+
+// HeaderSize es el tamaño fijo de la cabecera de una trama.
+const HeaderSize = 10
+
+// MaxPayloadSize limita el tamaño de un payload para no reservar memoria
+// arbitraria a partir de una longitud recibida por la red.
+const MaxPayloadSize = 64 << 20
+
+// FrameType identifica el tipo de mensaje que transporta una trama.
+type FrameType uint8
+
+const (
+	FrameTransfer     FrameType = iota + 1 // Envío de archivo
+	FrameDelete                            // Eliminación de archivo o carpeta
+	FrameSyncRequest                       // Solicitud de operaciones
+	FrameSync                              // Respuesta con operaciones
+	FrameList                              // Árbol de archivos de shared/
+	FrameView                              // Lista plana de archivos de shared/
+	FrameChunk                             // Bloque binario de un archivo en tránsito
+	FrameResume                            // Offset y bloques que le faltan al receptor
+	FrameFetch                             // Solicitud de contenido por hash
+	FrameSignature                         // Firma rsync de la copia del receptor
+	FrameDelta                             // Operación delta binaria (copia/literal/fin)
+	FramePing                              // Sondeo directo de membresía (SWIM)
+	FramePingReq                           // Sondeo indirecto a través de otro miembro
+	FrameAck                               // Respuesta a PING / PING_REQ
+	FrameCursors                           // Cursores de SYNC de un nodo (confirmación de lápidas)
+	FrameMerkle                            // Subárbol de Merkle de shared/ (anti-entropía)
+	FrameStat                              // Versión de un archivo en una réplica (quórum)
+	FrameTransferAck                       // Copia recibida, verificada y guardada
+	FrameTransferNack                      // Copia recibida pero descartada (hash, disco, ZIP)
+	FrameDeleteAck                         // DELETE aplicado (o ya reflejado) por el receptor
+	FrameDeleteNack                        // DELETE rechazado o no aplicado
+)
+
+// frameNames relaciona cada tipo de trama con el nombre usado en Message.Type.
+var frameNames = map[FrameType]string{
+	FrameTransfer:     "TRANSFER",
+	FrameDelete:       "DELETE",
+	FrameSyncRequest:  "SYNC_REQUEST",
+	FrameSync:         "SYNC",
+	FrameList:         "LIST",
+	FrameView:         "VIEW",
+	FrameChunk:        "CHUNK",
+	FrameResume:       "RESUME",
+	FrameFetch:        "FETCH",
+	FrameSignature:    "SIGNATURE",
+	FrameDelta:        "DELTA",
+	FramePing:         "PING",
+	FramePingReq:      "PING_REQ",
+	FrameAck:          "ACK",
+	FrameCursors:      "CURSORS",
+	FrameMerkle:       "MERKLE",
+	FrameStat:         "STAT",
+	FrameTransferAck:  "TRANSFER_ACK",
+	FrameTransferNack: "TRANSFER_NACK",
+	FrameDeleteAck:    "DELETE_ACK",
+	FrameDeleteNack:   "DELETE_NACK",
+}
+
+var (
+	ErrBadMagic           = errors.New("trama con magic inválido")
+	ErrUnsupportedVersion = errors.New("versión de protocolo no soportada")
+	ErrPayloadTooLarge    = errors.New("payload excede el tamaño máximo")
+	ErrUnknownType        = errors.New("tipo de mensaje desconocido")
+)
+
+// Frame es una trama ya leída de la conexión.
+type Frame struct {
+	Version uint8
+	Type    FrameType
+	Payload []byte
+}
+
+func (t FrameType) String() string {
+	if name, ok := frameNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("FRAME_%d", uint8(t))
+}
+
+// FrameTypeOf retorna el tipo de trama correspondiente a un Message.Type
+func FrameTypeOf(msgType string) (FrameType, bool) {
+	for t, name := range frameNames {
+		if name == msgType {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// WriteFrame escribe una trama completa (cabecera + payload)
+func WriteFrame(w io.Writer, t FrameType, payload []byte) error {
+	if len(payload) > MaxPayloadSize {
+		return ErrPayloadTooLarge
+	}
+
+	var header [HeaderSize]byte
+	copy(header[0:4], Magic[:])
+	header[4] = ProtocolVersion
+	header[5] = byte(t)
+	binary.BigEndian.PutUint32(header[6:10], uint32(len(payload)))
+
+	// Una sola escritura evita que la cabecera y el payload se separen
+	var buf bytes.Buffer
+	buf.Grow(HeaderSize + len(payload))
+	buf.Write(header[:])
+	buf.Write(payload)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ReadFrame lee y valida una trama completa
+func ReadFrame(r io.Reader) (Frame, error) {
+	var header [HeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return Frame{}, err
+	}
+
+	if !bytes.Equal(header[0:4], Magic[:]) {
+		return Frame{}, ErrBadMagic
+	}
+	if header[4] != ProtocolVersion {
+		return Frame{}, fmt.Errorf("%w: %d", ErrUnsupportedVersion, header[4])
+	}
+
+	length := binary.BigEndian.Uint32(header[6:10])
+	if length > MaxPayloadSize {
+		return Frame{}, ErrPayloadTooLarge
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return Frame{}, fmt.Errorf("payload incompleto: %w", err)
+	}
+
+	return Frame{
+		Version: header[4],
+		Type:    FrameType(header[5]),
+		Payload: payload,
+	}, nil
+}
+
+// WriteMessage firma un Message, lo codifica como JSON y lo envía en una trama
+func WriteMessage(w io.Writer, msg Message) error {
+	t, ok := FrameTypeOf(msg.Type)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownType, msg.Type)
+	}
+	msg.Sign()
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("error al codificar mensaje: %w", err)
+	}
+	return WriteFrame(w, t, payload)
+}
+
+// ReadMessage lee una trama y decodifica su payload como Message
+func ReadMessage(r io.Reader) (Message, error) {
+	frame, err := ReadFrame(r)
+	if err != nil {
+		return Message{}, err
+	}
+	return DecodeMessage(frame)
+}
+
+// DecodeMessage interpreta el payload de una trama como Message y verifica
+// su firma. El tipo de la trama manda sobre el campo Type del JSON.
+func DecodeMessage(frame Frame) (Message, error) {
+	name, ok := frameNames[frame.Type]
+	if !ok {
+		return Message{}, fmt.Errorf("%w: %d", ErrUnknownType, frame.Type)
+	}
+
+	var msg Message
+	if err := json.Unmarshal(frame.Payload, &msg); err != nil {
+		return Message{}, fmt.Errorf("error al parsear mensaje: %w", err)
+	}
+	msg.Type = name
+	if err := msg.Verify(); err != nil {
+		return Message{}, fmt.Errorf("mensaje %s rechazado de %s: %w", name, msg.NodeID, err)
+	}
+	return msg, nil
+}
@@ -1,11 +1,41 @@
 package message
 
+import "p2pfs/internal/fs"
+
 // Message representa un mensaje entre nodos del sistema P2P.
 type Message struct {
-	Type   string // "TRANSFER", "DELETE", "VIEW", "SYNC", "SYNC_REQUEST"
-	Origin int    // ID del nodo que envió el mensaje
-	Target int    // ID del nodo destino (0 para broadcast)
-	Path   string // Ruta del archivo afectado
-	Data   []byte // Contenido del archivo (para TRANSFER o SYNC)
-	Time   int64  // Timestamp UNIX de la operación
+	Type      string            // "TRANSFER", "DELETE", "VIEW", "LIST", "SYNC", "SYNC_REQUEST", "RESUME", "FETCH", "SIGNATURE", "PING", "PING_REQ", "ACK", "STAT", "TRANSFER_ACK", "TRANSFER_NACK", "DELETE_ACK", "DELETE_NACK"
+	Origin    int               // ID del nodo que envió el mensaje
+	Target    int               // ID del nodo destino (0 para broadcast)
+	Path      string            // Ruta del archivo afectado
+	Hash      string            // SHA-256 esperado del contenido (para TRANSFER)
+	Size      int64             // Tamaño total del archivo (para TRANSFER)
+	ChunkSize int               // Tamaño de los bloques CHUNK que siguen a TRANSFER
+	Chunks    []string          // SHA-256 de cada bloque del archivo (TRANSFER)
+	Offset    int64             // Bytes ya verificados por el receptor (RESUME); -1 = rechazo
+	Need      []int             // Índices de bloques que el receptor no tiene (RESUME)
+	Data      []byte            // Payload en línea (operaciones SYNC, lista VIEW, firma SIGNATURE)
+	Time      int64             // Timestamp UNIX de la operación
+	FileTree  *fs.FileNode      // Árbol de archivos (respuesta a LIST)
+	Probe     string            // IP:puerto del miembro a sondear (PING_REQ)
+	Gossip    []MemberUpdate    // Cambios de membresía difundidos (PING, PING_REQ, ACK)
+	Cursors   map[string]uint64 // NodeID → última Seq contigua que ya se tiene (SYNC_REQUEST)
+	More      bool              // Quedan más páginas de SYNC
+	Reason    string            // Motivo del rechazo de una copia o un borrado (TRANSFER_NACK, DELETE_NACK) o de una consulta sin respuesta
+
+	// Firma del emisor; WriteMessage la añade y DecodeMessage la verifica
+	NodeID string // NodeID del emisor, derivado de PubKey
+	PubKey []byte // Clave pública Ed25519 del emisor
+	Sig    []byte // Firma Ed25519 del mensaje sin este campo
+}
+
+// MemberUpdate es el estado de un miembro tal como lo difunde el protocolo
+// de membresía: gana la actualización con mayor encarnación.
+type MemberUpdate struct {
+	NodeID      string
+	ID          int
+	IP          string
+	Port        string
+	State       string // "alive", "suspect", "dead"
+	Incarnation uint64
 }
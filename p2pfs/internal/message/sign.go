@@ -0,0 +1,27 @@
+package message
+
+import (
+	"encoding/json"
+
+	"p2pfs/internal/identity"
+)
+
+// Sign firma el mensaje con la identidad del nodo local
+func (m *Message) Sign() {
+	id := identity.Current
+	m.NodeID = id.NodeID
+	m.PubKey = id.Public
+	m.Sig = id.Sign(m.signedBytes())
+}
+
+// Verify comprueba que el mensaje esté firmado por el nodo que indica
+func (m Message) Verify() error {
+	return identity.Verify(m.NodeID, m.PubKey, m.signedBytes(), m.Sig)
+}
+
+// signedBytes es la serialización que se firma: todo menos Sig
+func (m Message) signedBytes() []byte {
+	m.Sig = nil
+	data, _ := json.Marshal(m)
+	return data
+}
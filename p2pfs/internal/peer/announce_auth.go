@@ -0,0 +1,122 @@
+package peer
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"p2pfs/internal/config"
+)
+
+// Autenticación de los anuncios UDP: con un secreto de clúster configurado,
+// cada HELLO/ASSIGN_ID/NEW_NODE lleva un HMAC-SHA256 sobre el anuncio
+// completo (incluidos timestamp y nonce). El receptor descarta los que no
+// verifican, los que están fuera de la ventana de tiempo y los repetidos.
+
+var (
+	ErrAnnounceUnsigned = errors.New("anuncio sin firma")
+	ErrAnnounceBadMAC   = errors.New("firma HMAC inválida")
+	ErrAnnounceStale    = errors.New("timestamp fuera de la ventana permitida")
+	ErrAnnounceReplay   = errors.New("nonce repetido")
+)
+
+// RejectReportInterval es cada cuánto se informan como mucho los anuncios
+// descartados. Cualquiera puede mandar datagramas a la subred, así que los
+// rechazos no van al oplog (que se reescribe entero en cada operación) sino
+// a la salida estándar, resumidos.
+const RejectReportInterval = 10 * time.Second
+
+var (
+	lastRejectReport time.Time
+	rejectsSilenced  int
+	rejectMutex      sync.Mutex
+)
+
+// Nonces ya vistos → instante a partir del cual se pueden olvidar
+var (
+	seenNonces = make(map[string]time.Time)
+	nonceMutex sync.Mutex
+)
+
+// encodeAnnouncement serializa msg sellándolo con timestamp, nonce y HMAC
+func encodeAnnouncement(msg NodeAnnouncement) []byte {
+	msg.Timestamp = time.Now().Unix()
+	nonce := make([]byte, 12)
+	rand.Read(nonce)
+	msg.Nonce = hex.EncodeToString(nonce)
+	msg.MAC = ""
+
+	if secret := config.Current.Discovery.Secret; secret != "" {
+		msg.MAC = announcementMAC(msg, secret)
+	}
+	data, _ := json.Marshal(msg)
+	return data
+}
+
+// announcementMAC calcula el HMAC del anuncio con el campo MAC vacío
+func announcementMAC(msg NodeAnnouncement, secret string) string {
+	msg.MAC = ""
+	data, _ := json.Marshal(msg)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyAnnouncement comprueba firma, frescura y unicidad de un anuncio.
+// Sin secreto configurado se aceptan todos (modo abierto).
+func verifyAnnouncement(msg NodeAnnouncement) error {
+	secret := config.Current.Discovery.Secret
+	if secret == "" {
+		return nil
+	}
+	if msg.MAC == "" {
+		return ErrAnnounceUnsigned
+	}
+	expected := announcementMAC(msg, secret)
+	if !hmac.Equal([]byte(msg.MAC), []byte(expected)) {
+		return ErrAnnounceBadMAC
+	}
+
+	window := time.Duration(config.Current.Discovery.MaxSkewSeconds) * time.Second
+	sent := time.Unix(msg.Timestamp, 0)
+	now := time.Now()
+	if sent.Before(now.Add(-window)) || sent.After(now.Add(window)) {
+		return fmt.Errorf("%w (%s)", ErrAnnounceStale, sent.Format(time.RFC3339))
+	}
+
+	nonceMutex.Lock()
+	defer nonceMutex.Unlock()
+	for n, expiry := range seenNonces {
+		if now.After(expiry) {
+			delete(seenNonces, n)
+		}
+	}
+	if _, seen := seenNonces[msg.Nonce]; seen {
+		return ErrAnnounceReplay
+	}
+	// Pasada la ventana el timestamp ya lo rechaza, no hace falta recordarlo más
+	seenNonces[msg.Nonce] = sent.Add(window)
+	return nil
+}
+
+// reportRejected informa un anuncio descartado, a lo sumo uno por
+// RejectReportInterval; los demás solo se cuentan para el siguiente aviso
+func reportRejected(msg NodeAnnouncement, sender fmt.Stringer, err error) {
+	rejectMutex.Lock()
+	defer rejectMutex.Unlock()
+	if time.Since(lastRejectReport) < RejectReportInterval {
+		rejectsSilenced++
+		return
+	}
+	fmt.Printf("🔒 Anuncio %s descartado desde %s (%s:%s): %v\n", msg.Type, sender, msg.IP, msg.Port, err)
+	if rejectsSilenced > 0 {
+		fmt.Printf("🔒 Otros %d anuncio(s) descartado(s) en los últimos %v\n", rejectsSilenced, RejectReportInterval)
+	}
+	lastRejectReport, rejectsSilenced = time.Now(), 0
+}
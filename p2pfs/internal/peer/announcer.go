@@ -1,7 +1,6 @@
 package peer
 
 import (
-	"encoding/json"
 	"fmt"
 	"net"
 	"os"
@@ -12,7 +11,12 @@ import (
 var BroadcastPort = getEnvOrDefault("DISCOVERY_PORT", "48999")
 const BroadcastInterval = 5 * time.Second
 
+// RejoinAttempts es cuántas veces se difunde REJOIN al arrancar con ID guardado
+const RejoinAttempts = 3
+
 // BroadcastHello emite periódicamente un mensaje HELLO por UDP broadcast
+// mientras el nodo no tenga ID. Si arrancó con un ID recuperado, en su lugar
+// difunde REJOIN unas cuantas veces para reclamarlo.
 func BroadcastHello(self *Peer) {
 	addr := net.UDPAddr{
 		IP:   net.IPv4bcast,
@@ -25,16 +29,32 @@ func BroadcastHello(self *Peer) {
 	}
 	defer conn.Close()
 
+	rejoins := 0
+	if self.ID != 0 {
+		rejoins = RejoinAttempts
+	}
+
 	for {
-		if self.ID == 0 {
+		if self.ID != 0 && rejoins > 0 {
+			rejoins--
 			msg := NodeAnnouncement{
-				Type: "HELLO",
-				IP:   self.IP,
-				Port: self.Port,
+				Type:   "REJOIN",
+				IP:     self.IP,
+				Port:   self.Port,
+				ID:     self.ID,
+				NodeID: self.NodeID,
 			}
-			data, _ := json.Marshal(msg)
-
-			_, err := conn.Write(data)
+			if _, err := conn.Write(encodeAnnouncement(msg)); err == nil {
+				fmt.Printf("📣 Enviado REJOIN con ID %d desde %s:%s\n", self.ID, self.IP, self.Port)
+			}
+		} else if self.ID == 0 {
+			msg := NodeAnnouncement{
+				Type:   "HELLO",
+				IP:     self.IP,
+				Port:   self.Port,
+				NodeID: self.NodeID,
+			}
+			_, err := conn.Write(encodeAnnouncement(msg))
 			if err == nil {
 				self.LastHelloSent = time.Now()
 				fmt.Println("📣 Enviado HELLO desde", self.IP+":"+self.Port)
@@ -63,7 +83,9 @@ func ListenForBroadcasts(self *Peer, getPeerList func() []PeerInfo) {
 		if err != nil {
 			continue
 		}
-		go handleBroadcastMessage(buf[:n], sender, self, getPeerList)
+		// Copiar: buf se reutiliza en la siguiente lectura
+		data := append([]byte(nil), buf[:n]...)
+		go handleBroadcastMessage(data, sender, self, getPeerList)
 	}
 }
 
@@ -0,0 +1,186 @@
+package peer
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"path/filepath"
+	"time"
+
+	"p2pfs/internal/fs"
+	"p2pfs/internal/log"
+	"p2pfs/internal/message"
+)
+
+// Anti-entropía: cada AntiEntropyInterval el nodo compara su shared/ con
+// el de un peer vivo al azar usando el árbol de Merkle (fs.BuildFileTree),
+// y se le envía al peer lo que le falta o tiene más viejo, si es dueño del
+// archivo (ver ring.go).
+// Como el peer hace lo mismo con nosotros, las diferencias se reparan en
+// ambos sentidos. Esto cubre lo que el oplog no ve, como archivos copiados
+// a mano a shared/.
+//
+// Qué versión es más vieja lo decide la historia de operaciones, no la
+// fecha de modificación: se pide la operación del peer con STAT y solo se
+// envía la copia local si su operación desciende de la de él. Si el peer
+// tiene una operación que no conocemos se aplica como si llegara por SYNC,
+// así que una versión concurrente pasa por la política de conflictos. Las
+// rutas con lápida no se tocan: el DELETE se propaga por SYNC.
+//
+// Las transferencias solo llevan el nombre del archivo, así que la
+// comparación se limita al primer nivel de shared/: las subcarpetas no se
+// recorren ni se reparan (una carpeta se replica entera, como ZIP, cuando
+// se envía desde la GUI).
+
+// AntiEntropyInterval es cada cuánto se compara shared/ con un peer
+const AntiEntropyInterval = 30 * time.Second
+
+// StartAntiEntropy corre la anti-entropía en segundo plano
+func (p *Peer) StartAntiEntropy() {
+	for {
+		time.Sleep(AntiEntropyInterval)
+		peers := p.LivePeers()
+		if len(peers) == 0 {
+			continue
+		}
+		info := peers[rand.Intn(len(peers))]
+		if _, err := p.AntiEntropyWith(info); err != nil {
+			fmt.Printf("⚠️ Anti-entropía con nodo %d: %v\n", info.ID, err)
+		}
+	}
+}
+
+// AntiEntropyWith compara shared/ con el de info y le envía los archivos
+// que le faltan o tiene desactualizados. Retorna cuántos se enviaron.
+func (p *Peer) AntiEntropyWith(info PeerInfo) (int, error) {
+	addr := net.JoinHostPort(info.IP, info.Port)
+	repaired, err := p.reconcile(info.NodeID, addr)
+	if repaired > 0 {
+		fmt.Printf("🌳 Anti-entropía con nodo %d: %d archivo(s) reparado(s)\n", info.ID, repaired)
+	}
+	return repaired, err
+}
+
+// reconcile compara los archivos del primer nivel de shared/ con los de
+// addr (nodo peerID)
+func (p *Peer) reconcile(peerID, addr string) (int, error) {
+	remote, err := p.requestSubtree(addr, ".")
+	if err != nil {
+		return 0, err
+	}
+	local, err := fs.Subtree("shared", ".")
+	if err != nil {
+		return 0, err
+	}
+	if local.Hash == remote.Hash {
+		return 0, nil
+	}
+
+	theirs := make(map[string]fs.FileNode, len(remote.Children))
+	for _, c := range remote.Children {
+		theirs[c.Name] = c
+	}
+
+	repaired := 0
+	for _, mine := range local.Children {
+		other, ok := theirs[mine.Name]
+		if mine.IsDir || (ok && (other.IsDir || other.Hash == mine.Hash)) {
+			continue // Iguales, o carpetas (fuera de la anti-entropía)
+		}
+		path := mine.Name
+		if _, dead := fs.TombstoneFor(filepath.Join("shared", path)); dead {
+			continue
+		}
+
+		switch {
+		case !containsNode(p.Owners(path), peerID):
+			// El peer no es dueño del archivo: no tiene por qué guardarlo
+		case !p.localWins(addr, path):
+			// La versión del peer es más nueva o concurrente: él nos la
+			// enviará o ya quedó resuelta por la política de conflictos
+		default:
+			if err := p.SendFile(filepath.Join("shared", path), addr); err != nil {
+				fmt.Printf("⚠️ No se pudo reparar %s en %s: %v\n", path, addr, err)
+				continue
+			}
+			repaired++
+		}
+	}
+	return repaired, nil
+}
+
+// localWins pide a addr su versión de path y decide si hay que enviarle la
+// local. Una operación del peer que no está en el log local se aplica
+// primero, trayendo su contenido si hace falta.
+func (p *Peer) localWins(addr, path string) bool {
+	remote, err := p.statAt(addr, path)
+	if err != nil {
+		fmt.Printf("⚠️ No se pudo consultar %s en %s: %v\n", path, addr, err)
+		return false
+	}
+	if remote.Op != nil && !logged(*remote.Op) {
+		p.fetchMissingContent(addr, []log.Operation{*remote.Op})
+		fs.SyncWithLogs([]log.Operation{*remote.Op})
+	}
+	return newer(localVersion(path), remote, log.NewHistory(log.ReadLocalLog()))
+}
+
+// newer indica si la versión local de un archivo es estrictamente más nueva
+// que la del peer: su operación desciende de la de él. Solo si ninguno de
+// los dos registró operaciones (archivos fuera del oplog) se usa la fecha
+// y, con la misma fecha, el hash, para que ambos lados coincidan.
+func newer(mine, other FileVersion, h log.History) bool {
+	switch {
+	case mine.Op == nil && other.Op == nil:
+		if !mine.Mod.Equal(other.Mod) {
+			return mine.Mod.After(other.Mod)
+		}
+		return mine.Hash > other.Hash
+	case mine.Op == nil:
+		return false
+	case other.Op == nil:
+		return true
+	case mine.Op.ID() == "" || other.Op.ID() == "":
+		// Operaciones de logs antiguos, sin historia: orden HLC
+		return other.Op.Before(*mine.Op)
+	}
+	return mine.Op.ID() != other.Op.ID() && h.Descends(*mine.Op, other.Op.ID())
+}
+
+// logged indica si op ya está en el log local. Las operaciones de logs
+// antiguos no tienen ID; de esas se encarga la deduplicación de SYNC.
+func logged(op log.Operation) bool {
+	if op.ID() == "" {
+		return false
+	}
+	for _, known := range log.ReadLocalLog() {
+		if known.ID() == op.ID() {
+			return true
+		}
+	}
+	return false
+}
+
+// requestSubtree pide a addr su subárbol de Merkle rel
+func (p *Peer) requestSubtree(addr, rel string) (fs.FileNode, error) {
+	resp, err := p.request(addr, message.Message{Type: "MERKLE", Origin: p.ID, Path: rel})
+	if err != nil {
+		return fs.FileNode{}, err
+	}
+	if resp.Type != "MERKLE" || resp.FileTree == nil {
+		return fs.FileNode{}, fmt.Errorf("subárbol %s no disponible en %s: %s", rel, addr, resp.Reason)
+	}
+	return *resp.FileTree, nil
+}
+
+// handleMerkle responde con el subárbol de Merkle pedido (sin nietos) o,
+// si no existe, con el motivo
+func (p *Peer) handleMerkle(conn net.Conn, msg message.Message) {
+	resp := message.Message{Type: "MERKLE", Origin: p.ID, Path: msg.Path}
+	if tree, err := fs.Subtree("shared", msg.Path); err == nil {
+		resp.FileTree = &tree
+	} else {
+		resp.Reason = err.Error()
+	}
+	message.WriteMessage(conn, resp)
+}
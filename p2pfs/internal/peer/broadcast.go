@@ -0,0 +1,170 @@
+package peer
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"p2pfs/internal/config"
+	"p2pfs/internal/fs"
+	"p2pfs/internal/log"
+	"p2pfs/internal/message"
+	"p2pfs/internal/utils"
+)
+
+// TransferResult es el resultado de enviar un archivo o un DELETE a un peer
+type TransferResult struct {
+	Peer   PeerInfo
+	Err    error // nil si se entregó
+	Queued bool  // Quedó en la cola de reintentos
+}
+
+// BroadcastFile envía filePath a los nodos que deben guardarlo según el
+// anillo (Owners; todos los peers si el factor de replicación es 0). Los
+// vivos según la membresía reciben el archivo en paralelo; los caídos no
+// se intentan y van directo a la cola de reintentos, identificados por su
+// NodeID.
+func (p *Peer) BroadcastFile(filePath string) ([]TransferResult, error) {
+	out, err := p.prepareFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer out.cleanup()
+
+	// Sin factor de replicación van todos, incluso los caídos (a la cola)
+	targets := p.Peers()
+	if config.Current.Replication.Factor > 0 {
+		targets = p.Owners(filePath)
+	}
+	task := utils.PendingTask{Type: "TRANSFER", FilePath: out.originalPath}
+	results := p.fanOut(task, out.filename, targets, func(addr string) error {
+		return p.sendPrepared(out, addr)
+	})
+
+	// Si este nodo no es dueño, la copia sobra cuando todos la confirmaron
+	p.handOff(filePath, func(owner PeerInfo) bool {
+		for _, r := range results {
+			if r.Peer.NodeID == owner.NodeID {
+				return r.Err == nil
+			}
+		}
+		return false
+	})
+	return results, nil
+}
+
+// DeleteFile borra filePath en todo el clúster: lo elimina localmente,
+// registra el DELETE firmado (que deja la lápida) y lo envía a los peers
+// vivos en paralelo. Los caídos, y aquellos a los que no se pudo entregar,
+// quedan en la cola de reintentos. El DELETE solo se registra si el
+// borrado local funcionó: si no, nunca llega al oplog ni al SYNC.
+func (p *Peer) DeleteFile(filePath string) ([]TransferResult, error) {
+	path := filepath.Clean(filePath)
+	fs.MarkIncoming(path)
+	if err := fs.DeletePath(path); err != nil {
+		return nil, fmt.Errorf("no se pudo eliminar %s: %w", path, err)
+	}
+
+	op := log.AppendToLocalLog(log.Operation{
+		Type: "DELETE",
+		Path: path,
+		Time: time.Now().Unix(),
+	})
+	// Ya borrado: solo deja la lápida
+	if err := fs.ApplyOperation(op); err != nil {
+		return nil, err
+	}
+	fmt.Printf("🗑️ %s eliminado, avisando al clúster\n", op.Path)
+
+	task := utils.PendingTask{Type: "DELETE", FilePath: op.Path}
+	return p.fanOut(task, filepath.Base(op.Path), p.Peers(), func(addr string) error {
+		return p.sendDelete(op, addr)
+	}), nil
+}
+
+// fanOut ejecuta send en paralelo para cada peer vivo de targets. Los
+// caídos no se intentan y, como los envíos fallidos, quedan en la cola
+// como task dirigida al NodeID del peer.
+func (p *Peer) fanOut(task utils.PendingTask, name string, targets []PeerInfo, send func(addr string) error) []TransferResult {
+	var (
+		results []TransferResult
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+	)
+	record := func(info PeerInfo, err error) {
+		r := TransferResult{Peer: info, Err: err}
+		if err != nil {
+			t := task
+			t.Target = net.JoinHostPort(info.IP, info.Port)
+			t.TargetID = info.NodeID
+			r.Queued = utils.AddPendingTask(t) == nil
+		}
+		mu.Lock()
+		results = append(results, r)
+		mu.Unlock()
+	}
+
+	for _, info := range append([]PeerInfo(nil), targets...) {
+		if info.NodeID == p.NodeID || (info.IP == p.IP && info.Port == p.Port) {
+			continue
+		}
+
+		if !p.IsAlive(info) {
+			fmt.Printf("⏳ Nodo %d fuera de línea, %s %s queda en cola\n", info.ID, task.Type, name)
+			record(info, fmt.Errorf("nodo fuera de línea"))
+			continue
+		}
+
+		wg.Add(1)
+		go func(info PeerInfo) {
+			defer wg.Done()
+			record(info, send(net.JoinHostPort(info.IP, info.Port)))
+		}(info)
+	}
+	wg.Wait()
+	return results
+}
+
+// DeleteAckTimeout es cuánto se espera a que el receptor aplique un DELETE
+const DeleteAckTimeout = 30 * time.Second
+
+// sendDelete entrega a addr el DELETE de op y espera a que el receptor lo
+// confirme. Un DELETE_NACK, o no recibir respuesta, cuenta como fallido.
+func (p *Peer) sendDelete(op log.Operation, addr string) error {
+	conn, err := p.dial(addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := message.WriteMessage(conn, p.deleteMessage(op)); err != nil {
+		return err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(DeleteAckTimeout))
+	resp, err := message.ReadMessage(conn)
+	if err != nil {
+		return fmt.Errorf("sin confirmación del receptor: %w", err)
+	}
+	switch resp.Type {
+	case "DELETE_ACK":
+		return nil
+	case "DELETE_NACK":
+		return fmt.Errorf("el receptor rechazó el borrado: %s", resp.Reason)
+	default:
+		return fmt.Errorf("se esperaba DELETE_ACK, llegó %s", resp.Type)
+	}
+}
+
+// latestDelete busca en el log local el último DELETE de path
+func latestDelete(path string) (log.Operation, bool) {
+	var found log.Operation
+	ok := false
+	for _, op := range log.ReadLocalLog() {
+		if op.Type == "DELETE" && op.Target() == filepath.Clean(path) && (!ok || found.Before(op)) {
+			found, ok = op, true
+		}
+	}
+	return found, ok
+}
@@ -0,0 +1,163 @@
+package peer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"p2pfs/internal/fs"
+	"p2pfs/internal/log"
+	"p2pfs/internal/message"
+	"p2pfs/internal/utils"
+)
+
+// Puesta al día al reconectar: cuando un nodo vuelve (arranque, REJOIN o
+// la membresía lo ve pasar de caído a vivo) se piden a los peers vivos las
+// operaciones posteriores a la última conocida, y los peers reintentan de
+// inmediato lo que tenían en cola para él.
+//
+// El SYNC_REQUEST lleva un cursor por nodo de origen (la última Seq que ya
+// se tiene de cada uno) y el que responde envía solo lo posterior, en
+// páginas de SYNC sobre la misma conexión. Las operaciones no llevan el
+// contenido: el que las recibe pide por FETCH los bloques que le falten.
+
+const (
+	// CatchUpPeers es a cuántos peers vivos se pide SYNC al ponerse al día
+	CatchUpPeers = 2
+	// SyncPageSize es cuántas operaciones van como máximo en cada SYNC
+	SyncPageSize = 256
+	// syncPageTimeout es la espera máxima por cada página de SYNC
+	syncPageTimeout = 10 * time.Second
+)
+
+// NodeIDs con una reconexión en curso (REJOIN y membresía pueden avisar a la vez)
+var reconnecting sync.Map
+
+// CatchUp pide las operaciones perdidas a hasta CatchUpPeers peers vivos
+func (p *Peer) CatchUp() {
+	asked := 0
+	for _, info := range p.LivePeers() {
+		if asked == CatchUpPeers {
+			break
+		}
+		if err := p.syncFrom(info); err != nil {
+			fmt.Printf("⚠️ No se pudo sincronizar con nodo %d: %v\n", info.ID, err)
+			continue
+		}
+		asked++
+	}
+}
+
+// syncFrom pide a info las operaciones posteriores a los cursores locales
+// y aplica cada página conforme llega
+func (p *Peer) syncFrom(info PeerInfo) error {
+	addr := net.JoinHostPort(info.IP, info.Port)
+	conn, err := p.dial(addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	err = message.WriteMessage(conn, message.Message{
+		Type:    "SYNC_REQUEST",
+		Origin:  p.ID,
+		Cursors: log.Cursors(),
+	})
+	if err != nil {
+		return err
+	}
+
+	total := 0
+	for {
+		conn.SetReadDeadline(time.Now().Add(syncPageTimeout))
+		page, err := message.ReadMessage(conn)
+		if err != nil {
+			return err
+		}
+		if page.Type != "SYNC" {
+			return fmt.Errorf("respuesta inesperada a SYNC_REQUEST: %s", page.Type)
+		}
+
+		var ops []log.Operation
+		if err := json.Unmarshal(page.Data, &ops); err != nil {
+			return fmt.Errorf("operaciones SYNC inválidas: %w", err)
+		}
+		total += len(ops)
+		p.applySync(addr, ops)
+
+		if !page.More {
+			break
+		}
+	}
+	fmt.Printf("🔄 Nodo %d envió %d operación(es) nueva(s)\n", info.ID, total)
+	return nil
+}
+
+// handleSyncRequest envía al solicitante las operaciones posteriores a sus
+// cursores, en páginas de hasta SyncPageSize
+func (p *Peer) handleSyncRequest(conn net.Conn, msg message.Message) {
+	// Los cursores del solicitante confirman los DELETE que ya tiene
+	fs.AckTombstones(msg.NodeID, msg.Cursors)
+
+	ops := log.OpsAfter(msg.Cursors)
+	for start := 0; ; start += SyncPageSize {
+		end := start + SyncPageSize
+		if end > len(ops) {
+			end = len(ops)
+		}
+		payload, _ := json.Marshal(ops[start:end])
+		err := message.WriteMessage(conn, message.Message{
+			Type:   "SYNC",
+			Origin: p.ID,
+			Data:   payload,
+			Time:   time.Now().Unix(),
+			More:   end < len(ops),
+		})
+		if err != nil {
+			fmt.Printf("⚠️ SYNC a nodo %d interrumpido: %v\n", msg.Origin, err)
+			return
+		}
+		if end == len(ops) {
+			return
+		}
+	}
+}
+
+// applySync trae de addr el contenido que falte y aplica las operaciones
+func (p *Peer) applySync(addr string, ops []log.Operation) {
+	if addr != "" {
+		p.fetchMissingContent(addr, ops)
+	}
+	fs.SyncWithLogs(ops)
+}
+
+// onReconnect reacciona a un peer que vuelve: vacía su cola de reintentos
+// y se pone al día con él por si nos perdimos algo mientras no nos veíamos
+func (p *Peer) onReconnect(info PeerInfo) {
+	if _, busy := reconnecting.LoadOrStore(info.NodeID, true); busy {
+		return
+	}
+	defer reconnecting.Delete(info.NodeID)
+
+	fmt.Printf("🔌 Nodo %d de vuelta, sincronizando\n", info.ID)
+	p.flushRetries(info)
+	if err := p.syncFrom(info); err != nil {
+		fmt.Printf("⚠️ No se pudo sincronizar con nodo %d: %v\n", info.ID, err)
+	}
+}
+
+// flushRetries reintenta ya las tareas en cola destinadas a info
+func (p *Peer) flushRetries(info PeerInfo) {
+	tasks, err := utils.LoadRetryQueue()
+	if err != nil {
+		return
+	}
+	addr := net.JoinHostPort(info.IP, info.Port)
+	for _, task := range tasks {
+		if task.TargetID == info.NodeID || (task.TargetID == "" && task.Target == addr) {
+			p.retryTask(task)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package peer
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+
+	"p2pfs/internal/delta"
+	"p2pfs/internal/message"
+	"p2pfs/internal/store"
+	"p2pfs/internal/utils"
+)
+
+// chunkStream expone como io.Reader secuencial los bloques de un chunkReader
+type chunkStream struct {
+	read  chunkReader
+	next  int
+	count int
+	buf   []byte
+}
+
+func (s *chunkStream) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.next >= s.count {
+			return 0, io.EOF
+		}
+		data, err := s.read(s.next)
+		if err != nil {
+			return 0, err
+		}
+		s.buf = data
+		s.next++
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// sendDelta responde a una SIGNATURE con las operaciones que transforman la
+// copia del receptor en el archivo que se envía
+func sendDelta(w io.Writer, header, resp message.Message, read chunkReader) (transferResult, error) {
+	res := transferResult{Delta: true}
+
+	var sig delta.Signature
+	if err := json.Unmarshal(resp.Data, &sig); err != nil {
+		return res, fmt.Errorf("firma delta inválida: %w", err)
+	}
+	if sig.BlockSize < delta.MinBlockSize || sig.BlockSize > delta.MaxBlockSize {
+		return res, fmt.Errorf("tamaño de bloque delta inválido: %d", sig.BlockSize)
+	}
+
+	src := &chunkStream{read: read, count: len(header.Chunks)}
+	err := delta.Diff(sig, src, func(op delta.Op) error {
+		if op.Kind == delta.OpLiteral {
+			res.Literal += int64(len(op.Data))
+		}
+		return message.WriteFrame(w, message.FrameDelta, delta.EncodeOp(op))
+	})
+	return res, err
+}
+
+// deltaBase indica si conviene reconstruir el archivo entrante a partir de
+// la copia local en destPath: solo si existe con otro contenido y el
+// almacén no tiene ya todos los bloques nuevos.
+func deltaBase(destPath string, header message.Message) bool {
+	info, err := os.Stat(destPath)
+	if err != nil || !info.Mode().IsRegular() || info.Size() == 0 {
+		return false
+	}
+
+	// Una transferencia a medias se reanuda en lugar de empezar un delta
+	if _, ok := utils.GetTransferState(header.Hash); ok {
+		return false
+	}
+
+	missing := 0
+	for _, c := range header.Chunks {
+		if !store.HasChunk(c) {
+			missing++
+		}
+	}
+	if missing == 0 {
+		return false
+	}
+
+	localHash, err := utils.CalculateSHA256(destPath)
+	return err == nil && localHash != header.Hash
+}
+
+// receiveDelta envía la firma de la copia local y reconstruye la versión
+// nueva en el archivo parcial aplicando las operaciones DELTA. Retorna el
+// SHA-256 del resultado y cuántos bytes literales llegaron por la red.
+func (p *Peer) receiveDelta(conn net.Conn, header message.Message, basePath string) (string, int64, error) {
+	base, err := os.Open(basePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer base.Close()
+
+	info, err := base.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+	sig, err := delta.ComputeSignature(base, delta.BlockSizeFor(info.Size()))
+	if err != nil {
+		return "", 0, err
+	}
+	data, _ := json.Marshal(sig)
+	if err := message.WriteMessage(conn, message.Message{
+		Type:   "SIGNATURE",
+		Origin: p.ID,
+		Hash:   header.Hash,
+		Data:   data,
+	}); err != nil {
+		return "", 0, err
+	}
+
+	partPath := utils.PartialPath(header.Hash)
+	if err := os.MkdirAll(filepath.Dir(partPath), 0755); err != nil {
+		return "", 0, err
+	}
+	out, err := os.Create(partPath)
+	if err != nil {
+		return "", 0, err
+	}
+
+	h := sha256.New()
+	w := io.MultiWriter(out, h)
+	var written, literal int64
+	for {
+		frame, err := message.ReadFrame(conn)
+		if err == nil && frame.Type != message.FrameDelta {
+			err = fmt.Errorf("se esperaba DELTA, llegó %s", frame.Type)
+		}
+		var op delta.Op
+		if err == nil {
+			op, err = delta.DecodeOp(frame.Payload)
+		}
+		if err != nil {
+			out.Close()
+			os.Remove(partPath)
+			return "", literal, err
+		}
+		if op.Kind == delta.OpEnd {
+			break
+		}
+
+		n, err := delta.Apply(op, sig, base, w)
+		written += n
+		if err == nil && written > header.Size {
+			err = fmt.Errorf("el delta excede el tamaño anunciado %d", header.Size)
+		}
+		if err != nil {
+			out.Close()
+			os.Remove(partPath)
+			return "", literal, err
+		}
+		if op.Kind == delta.OpLiteral {
+			literal += int64(len(op.Data))
+		}
+	}
+	out.Close()
+
+	if written != header.Size {
+		os.Remove(partPath)
+		return "", literal, fmt.Errorf("tamaño reconstruido %d distinto del anunciado %d", written, header.Size)
+	}
+
+	// El almacén se actualiza en handleTransfer, una vez verificado el hash
+	return fmt.Sprintf("%x", h.Sum(nil)), literal, nil
+}
@@ -1,91 +1,363 @@
 package peer
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
 	"p2pfs/internal/fs"
 	"p2pfs/internal/log"
 	"p2pfs/internal/message"
-	"time"
+	"p2pfs/internal/store"
+	"p2pfs/internal/utils"
 )
 
-// StartServer inicia un servidor TCP para recibir mensajes entrantes
-func StartServer(port string) {
-	addr := ":" + port
-	listener, err := net.Listen("tcp", addr)
+// handleConnection lee una trama y la despacha según su tipo
+func (p *Peer) handleConnection(conn net.Conn) {
+	defer conn.Close()
+
+	// Con TLS, rechazar antes de leer nada a quien no presente un
+	// certificado firmado por la CA del clúster
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(10 * time.Second))
+		if err := tlsConn.Handshake(); err != nil {
+			fmt.Printf("🔒 Conexión rechazada desde %s: %v\n", conn.RemoteAddr(), err)
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
+	}
+
+	msg, err := message.ReadMessage(conn)
 	if err != nil {
-		fmt.Printf("❌ Error al iniciar servidor: %v\n", err)
+		fmt.Printf("⚠️ Trama inválida desde %s: %v\n", conn.RemoteAddr(), err)
 		return
 	}
-	defer listener.Close()
 
-	fmt.Printf("🛰️  Servidor escuchando en %s...\n", addr)
+	p.Membership().heartbeat(msg.NodeID)
+	if msg.Type != "PING" && msg.Type != "PING_REQ" && msg.Type != "CURSORS" && msg.Type != "MERKLE" && msg.Type != "STAT" {
+		fmt.Printf("📩 Mensaje recibido: %s desde nodo %d\n", msg.Type, msg.Origin)
+	}
 
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			fmt.Printf("⚠️ Error al aceptar conexión: %v\n", err)
-			continue
+	switch msg.Type {
+	case "TRANSFER":
+		p.handleTransfer(conn, msg)
+
+	case "DELETE":
+		p.handleDelete(conn, msg)
+
+	case "SYNC_REQUEST":
+		p.handleSyncRequest(conn, msg)
+
+	case "SYNC":
+		var ops []log.Operation
+		if err := json.Unmarshal(msg.Data, &ops); err != nil {
+			fmt.Printf("❌ Error al parsear operaciones SYNC: %v\n", err)
+			return
+		}
+		addr := ""
+		if origin := p.FindPeerByID(msg.Origin); origin != nil {
+			addr = net.JoinHostPort(origin.IP, origin.Port)
 		}
-		go handleConnection(conn)
+		p.applySync(addr, ops)
+
+	case "FETCH":
+		p.handleFetch(conn, msg)
+
+	case "LIST":
+		p.handleList(conn)
+
+	case "VIEW":
+		p.handleView(conn)
+
+	case "PING":
+		p.handlePing(conn, msg)
+
+	case "PING_REQ":
+		p.handlePingReq(conn, msg)
+
+	case "STAT":
+		p.handleStat(conn, msg)
+
+	case "MERKLE":
+		p.handleMerkle(conn, msg)
+
+	case "CURSORS":
+		message.WriteMessage(conn, message.Message{
+			Type:    "CURSORS",
+			Origin:  p.ID,
+			Cursors: log.Cursors(),
+		})
+
+	default:
+		fmt.Printf("⚠️ Tipo de mensaje no soportado: %s\n", msg.Type)
 	}
 }
 
-// handleConnection decodifica y ejecuta un mensaje entrante
-func handleConnection(conn net.Conn) {
-	defer conn.Close()
+// handleTransfer recibe los bloques de un archivo, verifica su hash y
+// descomprime ZIPs. Al terminar responde TRANSFER_ACK o, si la copia se
+// descartó, TRANSFER_NACK con el motivo, para que el emisor reintente.
+func (p *Peer) handleTransfer(conn net.Conn, msg message.Message) {
+	from := conn.RemoteAddr().String()
 
-	data, err := io.ReadAll(conn)
-	if err != nil {
-		fmt.Printf("⚠️ Error al leer datos: %v\n", err)
+	// Solo el nombre: nunca escribir fuera de shared/
+	filename := filepath.Base(msg.Path)
+	destPath := "shared/" + filename
+
+	if err := validTransferHeader(msg); err != nil {
+		fmt.Println("⚠️", err)
+		return
+	}
+	op, signed := transferOp(msg, destPath)
+	if t, ok := fs.TombstoneFor(destPath); ok && (!signed || !fs.Revives(op)) {
+		// La copia no desciende del borrado (p. ej. un reintento en cola o
+		// una réplica vieja): aceptarla resucitaría el archivo
+		fmt.Printf("🪦 %s fue borrado por %s y esta copia no lo sabía, rechazado\n", filename, t.NodeID)
+		message.WriteMessage(conn, message.Message{Type: "RESUME", Origin: p.ID, Hash: msg.Hash, Offset: -1})
 		return
 	}
+	if !claimTransfer(msg.Hash) {
+		fmt.Println("⚠️ Transferencia ya en curso para", filename)
+		message.WriteMessage(conn, message.Message{Type: "RESUME", Origin: p.ID, Hash: msg.Hash, Offset: -1})
+		return
+	}
+	defer releaseTransfer(msg.Hash)
+
+	// Si ya hay otra versión del archivo, reconstruir con un delta; si no,
+	// los bloques se escriben a disco conforme llegan
+	var actualHash, detail string
+	var err error
+	deltaBuilt := deltaBase(destPath, msg)
+	if deltaBuilt {
+		var literal int64
+		actualHash, literal, err = p.receiveDelta(conn, msg, destPath)
+		detail = fmt.Sprintf(" (delta, %d bytes literales)", literal)
+	} else {
+		actualHash, err = p.receiveFile(conn, msg, filename)
+	}
+	if err != nil {
+		// El parcial y el diario se conservan para reanudar
+		fmt.Println("Error al recibir archivo:", err)
 
-	var msg message.Message
-	if err := json.Unmarshal(data, &msg); err != nil {
-		fmt.Printf("⚠️ Error al parsear mensaje: %v\n", err)
+		log.AppendToLocalLog(log.Operation{
+			Type:     "CHUNK_FAIL",
+			FileName: filename,
+			From:     from,
+			Time:     time.Now().Unix(),
+			Message:  err.Error(),
+		})
+		p.replyTransfer(conn, msg, "recepción incompleta: "+err.Error())
 		return
 	}
 
-	fmt.Printf("📩 Mensaje recibido: %s desde nodo %d\n", msg.Type, msg.Origin)
+	// Verificar hash
+	if actualHash == msg.Hash {
+		fmt.Println("✅ Hash verificado correctamente" + detail)
 
-	switch msg.Type {
-	case "TRANSFER":
-		if err := fs.SaveFile(msg.Path, msg.Data); err != nil {
-			fmt.Printf("❌ Error al guardar archivo: %v\n", err)
+		// Lo reconstruido con un delta entra al almacén recién ahora, ya
+		// verificado, como cualquier otra versión
+		if deltaBuilt {
+			if m, err := store.Import(utils.PartialPath(msg.Hash), msg.ChunkSize); err == nil && m.Hash == msg.Hash {
+				m.Name = filename
+				store.SaveManifest(m)
+			}
 		}
 
-	case "DELETE":
-		if err := fs.DeletePath(msg.Path); err != nil {
-			fmt.Printf("❌ Error al eliminar archivo: %v\n", err)
+		// Registrar la versión con el TRANSFER firmado por su origen
+		if signed {
+			fs.RecordReceived(op)
 		} else {
 			log.AppendToLocalLog(log.Operation{
-				Type: "DELETE",
-				Path: msg.Path,
-				Time: time.Now().Unix(),
+				Type:     "TRANSFER",
+				Path:     destPath,
+				FileName: filename,
+				From:     from,
+				Hash:     actualHash,
+				Size:     msg.Size,
+				Time:     time.Now().Unix(),
+				Message:  "Archivo recibido" + detail,
 			})
 		}
 
-	case "SYNC_REQUEST":
-		// Enviar nuestro log al solicitante
-		ops := log.ReadLocalLog()
-		payload, _ := json.Marshal(ops)
-		conn.Write(payload)
+		log.AppendToLocalLog(log.Operation{
+			Type:     "HASH_OK",
+			FileName: filename,
+			From:     from,
+			Time:     time.Now().Unix(),
+			Message:  "SHA256 válido",
+		})
+	} else {
+		fmt.Println("❌ Hash inválido")
+		fmt.Printf("Esperado: %s\nRecibido: %s\n", msg.Hash, actualHash)
+		utils.RemoveTransferState(msg.Hash)
 
-	case "SYNC":
-		var ops []log.Operation
-		if err := json.Unmarshal(msg.Data, &ops); err != nil {
-			fmt.Printf("❌ Error al parsear operaciones SYNC: %v\n", err)
+		log.AppendToLocalLog(log.Operation{
+			Type:     "HASH_FAIL",
+			FileName: filename,
+			From:     from,
+			Time:     time.Now().Unix(),
+			Message:  fmt.Sprintf("Esperado: %s, Recibido: %s", msg.Hash, actualHash),
+		})
+		p.replyTransfer(conn, msg, "hash inválido: "+actualHash)
+		return
+	}
+
+	if err := os.MkdirAll("shared", 0755); err != nil {
+		fmt.Println("Error al crear shared/:", err)
+		p.replyTransfer(conn, msg, err.Error())
+		return
+	}
+	if err := os.Rename(utils.PartialPath(msg.Hash), destPath); err != nil {
+		fmt.Println("Error al guardar archivo:", err)
+		p.replyTransfer(conn, msg, err.Error())
+		return
+	}
+	fs.MarkIncoming(destPath)
+	utils.RemoveTransferState(msg.Hash)
+
+	// Si es un ZIP, descomprimir
+	if strings.HasSuffix(filename, ".zip") {
+		fmt.Println("📦 ZIP detectado, descomprimiendo...")
+		// Lo descomprimido tampoco es un cambio local para el watcher
+		err := utils.UnzipFile(destPath, "shared/", fs.MarkIncoming)
+		if err != nil {
+			fmt.Println("❌ Error al descomprimir:", err)
+
+			log.AppendToLocalLog(log.Operation{
+				Type:     "UNZIP_FAIL",
+				FileName: filename,
+				From:     from,
+				Time:     time.Now().Unix(),
+				Message:  err.Error(),
+			})
+			p.replyTransfer(conn, msg, "error al descomprimir: "+err.Error())
 			return
 		}
-		fs.SyncWithLogs(ops, fs.GetLastSyncTime())
+		os.Remove(destPath)
+		fmt.Println("✅ Descompresión exitosa")
 
-	case "VIEW":
-		// En versiones futuras podrías retornar vista de archivos como respuesta
+		log.AppendToLocalLog(log.Operation{
+			Type:     "UNZIP",
+			FileName: filename,
+			From:     from,
+			Time:     time.Now().Unix(),
+			Message:  "ZIP descomprimido correctamente",
+		})
+	} else {
+		store.Materialize(msg.Hash, destPath)
+		fmt.Println("📥 Archivo recibido como:", filename)
+	}
+	p.replyTransfer(conn, msg, "")
+}
 
-	default:
-		fmt.Printf("⚠️ Tipo de mensaje no soportado: %s\n", msg.Type)
+// transferOp extrae de la cabecera el TRANSFER firmado de la versión que
+// llega. Solo vale si es de este contenido y esta ruta.
+func transferOp(header message.Message, destPath string) (log.Operation, bool) {
+	var op log.Operation
+	if len(header.Data) == 0 || json.Unmarshal(header.Data, &op) != nil {
+		return op, false
+	}
+	valid := op.Type == "TRANSFER" && op.Hash == header.Hash &&
+		op.Target() == filepath.Clean(destPath) && op.Verify() == nil
+	return op, valid
+}
+
+// replyTransfer le informa al emisor el resultado de una transferencia:
+// TRANSFER_ACK si reason está vacío, TRANSFER_NACK con reason si no
+func (p *Peer) replyTransfer(conn net.Conn, header message.Message, reason string) {
+	reply := message.Message{Type: "TRANSFER_ACK", Origin: p.ID, Path: header.Path, Hash: header.Hash}
+	if reason != "" {
+		reply.Type, reply.Reason = "TRANSFER_NACK", reason
+	}
+	if err := message.WriteMessage(conn, reply); err != nil {
+		fmt.Printf("⚠️ No se pudo confirmar %s al emisor: %v\n", header.Path, err)
+	}
+}
+
+// deleteMessage arma el DELETE de una operación ya registrada en el log
+// local, firmada y numerada, para que cada receptor pueda probar quién
+// ordenó el borrado
+func (p *Peer) deleteMessage(op log.Operation) message.Message {
+	data, _ := json.Marshal(op)
+	return message.Message{
+		Type:   "DELETE",
+		Origin: p.ID,
+		Path:   op.Path,
+		Data:   data,
+		Time:   op.Time,
+	}
+}
+
+// handleDelete aplica un DELETE remoto si la operación que trae está
+// firmada y no es anterior a lo que ya se sabe de esa ruta, y la registra
+// con la firma de su nodo de origen. Responde DELETE_ACK si la operación
+// quedó registrada (aplicada o ya reflejada) y DELETE_NACK si no.
+func (p *Peer) handleDelete(conn net.Conn, msg message.Message) {
+	var op log.Operation
+	if err := json.Unmarshal(msg.Data, &op); err != nil || op.Type != "DELETE" || op.Path != msg.Path {
+		fmt.Printf("🔏 DELETE de %s sin operación firmada válida, ignorado\n", msg.NodeID)
+		p.replyDelete(conn, msg, "sin operación firmada válida")
+		return
+	}
+	if fs.SyncWithLogs([]log.Operation{op}) == 1 {
+		fmt.Printf("🗑️ %s eliminado por orden del nodo %s\n", op.Path, op.NodeID)
+	} else if !logged(op) {
+		// Firma inválida o error al borrar: SyncWithLogs no la registró
+		p.replyDelete(conn, msg, "no se pudo aplicar el borrado")
+		return
+	}
+	p.replyDelete(conn, msg, "")
+}
+
+// replyDelete le informa al emisor el resultado de un DELETE: DELETE_ACK
+// si reason está vacío, DELETE_NACK con reason si no
+func (p *Peer) replyDelete(conn net.Conn, msg message.Message, reason string) {
+	reply := message.Message{Type: "DELETE_ACK", Origin: p.ID, Path: msg.Path}
+	if reason != "" {
+		reply.Type, reply.Reason = "DELETE_NACK", reason
+	}
+	if err := message.WriteMessage(conn, reply); err != nil {
+		fmt.Printf("⚠️ No se pudo confirmar el borrado de %s al emisor: %v\n", msg.Path, err)
+	}
+}
+
+// fetchMissingContent obtiene de addr el contenido de las operaciones
+// TRANSFER de rutas que guarda este nodo y que el almacén todavía no tiene
+func (p *Peer) fetchMissingContent(addr string, ops []log.Operation) {
+	for _, op := range ops {
+		if op.Type != "TRANSFER" || op.Hash == "" || store.Complete(op.Hash) || !p.Holds(op.Target()) {
+			continue
+		}
+		if err := p.fetchContent(addr, op.Hash); err != nil {
+			fmt.Printf("⚠️ No se pudo obtener %s: %v\n", op.FileName, err)
+		}
+	}
+}
+
+// handleList responde con el árbol de archivos de shared/ o, si no se
+// pudo armar, con el motivo
+func (p *Peer) handleList(conn net.Conn) {
+	resp := message.Message{Type: "LIST", Origin: p.ID}
+	if tree, err := fs.BuildFileTree("shared"); err == nil {
+		resp.FileTree = &tree
+	} else {
+		resp.Reason = err.Error()
+	}
+	message.WriteMessage(conn, resp)
+}
+
+// handleView responde con la lista plana de archivos de shared/ o, si no
+// se pudo leer, con el motivo
+func (p *Peer) handleView(conn net.Conn) {
+	resp := message.Message{Type: "VIEW", Origin: p.ID}
+	if files, err := fs.ListFiles("shared"); err == nil {
+		resp.Data, _ = json.Marshal(files)
+	} else {
+		resp.Reason = err.Error()
 	}
+	message.WriteMessage(conn, resp)
 }
@@ -9,49 +9,12 @@ import (
 	"time"
 )
 
-// PeerInfo representa un nodo en la red
-type PeerInfo struct {
-	ID   int
-	IP   string
-	Port string
-}
-
 type HandshakeMessage struct {
 	Type       string   `json:"type"`
 	From       string   `json:"from"`
 	KnownPeers []string `json:"known_peers,omitempty"`
 }
 
-// Mapa de estado de peers vivos
-var peerStatuses = make(map[string]bool)
-
-// CheckPeerAlive intenta conectarse a un peer
-func CheckPeerAlive(peer PeerInfo) bool {
-	address := net.JoinHostPort(peer.IP, peer.Port)
-	conn, err := net.DialTimeout("tcp", address, 1*time.Second)
-	if err != nil {
-		peerStatuses[address] = false
-		return false
-	}
-	conn.Close()
-	peerStatuses[address] = true
-	return true
-}
-
-// GetLivePeers retorna los IDs de peers activos
-func GetLivePeers(peers []PeerInfo) []int {
-	var alive []int
-	for _, p := range peers {
-		if CheckPeerAlive(p) {
-			fmt.Printf("✅ Peer %d está en línea\n", p.ID)
-			alive = append(alive, p.ID)
-		} else {
-			fmt.Printf("❌ Peer %d no responde\n", p.ID)
-		}
-	}
-	return alive
-}
-
 // StartHandshakeListener inicia el servidor de descubrimiento
 func StartHandshakeListener(self PeerInfo, getPeerList func() []PeerInfo) {
 	ln, err := net.Listen("tcp", net.JoinHostPort(self.IP, self.Port))
@@ -4,23 +4,49 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
-	"os"
-	"strconv"
 	"sync"
 	"time"
+
+	"p2pfs/internal/log"
 )
 
 type NodeAnnouncement struct {
-	Type string `json:"type"` // "HELLO", "ASSIGN_ID", "NEW_NODE"
+	Type string `json:"type"` // "HELLO", "REJOIN", "ASSIGN_ID", "CLAIM_ID", "NEW_NODE"
 	IP   string `json:"ip"`
 	Port string `json:"port"`
 	ID   int    `json:"id,omitempty"`
+	// Identidad del nodo anunciado (derivada de su clave Ed25519)
+	NodeID string `json:"node_id,omitempty"`
+
+	// Autenticación (ver announce_auth.go)
+	Timestamp int64  `json:"ts"`
+	Nonce     string `json:"nonce"`
+	MAC       string `json:"mac,omitempty"`
 }
 
+// Asignación de IDs sin colisiones:
+//  1. El nodo nuevo emite HELLO y los nodos con ID le proponen uno (ASSIGN_ID).
+//     Si nadie responde a tiempo, se propone a sí mismo el primer ID libre.
+//  2. Difunde CLAIM_ID con la propuesta y espera ClaimWindow. Quien conozca
+//     otro dueño para ese ID se lo indica con un NEW_NODE del dueño.
+//  3. Si nadie lo disputa, lo confirma difundiendo NEW_NODE.
+//
+// Si dos nodos reclaman o ya tienen el mismo ID, gana el NodeID menor y el
+// otro repite el proceso con el siguiente ID libre. Todos los nodos aplican
+// la misma regla, así que coinciden en el dueño sin coordinarse.
+
+// ClaimWindow es cuánto espera un CLAIM_ID antes de confirmarse
+const ClaimWindow = 2 * time.Second
+
 var (
-	assignedIDs = make(map[string]int) // ip:port → ID
+	idOwners    = make(map[int]string) // ID → NodeID del dueño
+	assignedIDs = make(map[string]int) // NodeID → ID
 	nextID      = 1
 	idMutex     sync.Mutex
+
+	// Reclamo en curso del nodo local (0 = ninguno)
+	claimingID int
+	claimLost  bool
 )
 
 // ParseAndHandleAnnouncement maneja mensajes de descubrimiento e ID
@@ -31,79 +57,241 @@ func ParseAndHandleAnnouncement(data []byte, sender *net.UDPAddr, self *Peer, ge
 		return
 	}
 
-	senderKey := net.JoinHostPort(msg.IP, msg.Port)
+	if err := verifyAnnouncement(msg); err != nil {
+		reportRejected(msg, sender, err)
+		return
+	}
+
+	// Nuestros propios broadcasts también llegan aquí
+	if msg.NodeID == self.NodeID && msg.Type != "ASSIGN_ID" {
+		return
+	}
+	if msg.Type != "HELLO" && msg.ID <= 0 {
+		return
+	}
 
 	switch msg.Type {
 
 	case "HELLO":
+		// Solo proponen IDs los nodos que ya tienen uno
+		if self.ID == 0 {
+			return
+		}
 		idMutex.Lock()
-		defer idMutex.Unlock()
-
-		if _, exists := assignedIDs[senderKey]; !exists {
-			// Asignar nuevo ID al peer desconocido
-			newID := getNextAvailableID()
-			assignedIDs[senderKey] = newID
-			fmt.Printf("🆕 Asignando ID %d a %s\n", newID, senderKey)
-
-			// Responder directamente con ASSIGN_ID
-			assignMsg := NodeAnnouncement{
-				Type: "ASSIGN_ID",
-				IP:   msg.IP,
-				Port: msg.Port,
-				ID:   newID,
-			}
-			sendUDPMessage(assignMsg, msg.IP)
-
-			// Difundir NEW_NODE a todos
-			BroadcastNewNode(assignMsg)
+		newID, known := assignedIDs[msg.NodeID]
+		if !known {
+			newID = getNextAvailableID()
 		}
+		idMutex.Unlock()
+
+		fmt.Printf("🆕 Proponiendo ID %d a %s\n", newID, net.JoinHostPort(msg.IP, msg.Port))
+		sendUDPMessage(NodeAnnouncement{
+			Type:   "ASSIGN_ID",
+			IP:     msg.IP,
+			Port:   msg.Port,
+			ID:     newID,
+			NodeID: msg.NodeID,
+		}, msg.IP)
 
 	case "ASSIGN_ID":
-		if self.ID == 0 && msg.IP == self.IP && msg.Port == self.Port {
-			self.ID = msg.ID
-			self.LastIDAssigned = time.Now()
-			fmt.Printf("✅ ID %d asignado al nodo local\n", self.ID)
-
-			// Difundir nuestra existencia
-			newNode := NodeAnnouncement{
-				Type: "NEW_NODE",
-				IP:   self.IP,
-				Port: self.Port,
-				ID:   self.ID,
-			}
-			BroadcastNewNode(newNode)
+		if msg.NodeID == self.NodeID && self.ID == 0 {
+			ClaimID(self, msg.ID)
 		}
 
+	case "CLAIM_ID":
+		handleClaim(self, msg)
+
 	case "NEW_NODE":
-		idMutex.Lock()
-		defer idMutex.Unlock()
+		handleNewNode(self, msg)
 
-		if _, exists := assignedIDs[senderKey]; !exists {
-			assignedIDs[senderKey] = msg.ID
-			fmt.Printf("📢 Nodo %s registrado con ID %d\n", senderKey, msg.ID)
+	case "REJOIN":
+		// Un nodo conocido vuelve con su ID guardado: se registra como
+		// NEW_NODE (con la misma detección de duplicados) y se le responde
+		// con nuestro anuncio para que actualice su lista de peers
+		handleNewNode(self, msg)
+		if self.ID != 0 {
+			go self.onReconnect(PeerInfo{ID: msg.ID, NodeID: msg.NodeID, IP: msg.IP, Port: msg.Port})
+			go sendUDPMessage(NodeAnnouncement{
+				Type:   "NEW_NODE",
+				IP:     self.IP,
+				Port:   self.Port,
+				ID:     self.ID,
+				NodeID: self.NodeID,
+			}, msg.IP)
+		}
+	}
+}
+
+// ClaimID difunde un CLAIM_ID por id y lo adopta si nadie lo disputa
+// durante ClaimWindow. No hace nada si el nodo ya tiene ID o está reclamando.
+func ClaimID(self *Peer, id int) {
+	idMutex.Lock()
+	if self.ID != 0 || claimingID != 0 {
+		idMutex.Unlock()
+		return
+	}
+	claimingID = id
+	claimLost = false
+	idMutex.Unlock()
+
+	fmt.Printf("🙋 Reclamando ID %d\n", id)
+	BroadcastNewNode(NodeAnnouncement{
+		Type:   "CLAIM_ID",
+		IP:     self.IP,
+		Port:   self.Port,
+		ID:     id,
+		NodeID: self.NodeID,
+	})
+	time.AfterFunc(ClaimWindow, func() { finishClaim(self) })
+}
+
+// ClaimFreeID reclama el primer ID libre según lo que conoce el nodo.
+// Se usa cuando nadie respondió al HELLO.
+func ClaimFreeID(self *Peer) {
+	idMutex.Lock()
+	id := getNextAvailableID()
+	idMutex.Unlock()
+	ClaimID(self, id)
+}
+
+// finishClaim confirma el reclamo en curso o, si se perdió, reintenta con
+// el siguiente ID libre
+func finishClaim(self *Peer) {
+	idMutex.Lock()
+	id, lost := claimingID, claimLost
+	claimingID = 0
+	if lost {
+		idMutex.Unlock()
+		fmt.Printf("🔁 ID %d en uso por otro nodo, reintentando\n", id)
+		ClaimFreeID(self)
+		return
+	}
+	self.ID = id
+	self.LastIDAssigned = time.Now()
+	recordOwner(id, self.NodeID)
+	idMutex.Unlock()
+	self.SaveState()
 
-			if msg.ID >= nextID {
-				nextID = msg.ID + 1
-			}
+	fmt.Printf("✅ ID %d asignado al nodo local\n", id)
+	announceSelf(self)
+	go self.CatchUp()
+}
+
+// handleClaim responde a un CLAIM_ID ajeno si choca con un dueño conocido
+// o con nuestro propio reclamo
+func handleClaim(self *Peer, msg NodeAnnouncement) {
+	idMutex.Lock()
+	defer idMutex.Unlock()
 
-			// Agregar a lista de peers locales
-			self.AddPeer(PeerInfo{ID: msg.ID, IP: msg.IP, Port: msg.Port})
+	// Reclamos simultáneos del mismo ID: gana el NodeID menor
+	if claimingID == msg.ID {
+		if msg.NodeID < self.NodeID {
+			claimLost = true
+			recordOwner(msg.ID, msg.NodeID)
 		}
+		return
 	}
+
+	owner, taken := idOwners[msg.ID]
+	if !taken || owner == msg.NodeID {
+		return
+	}
+	// Avisar al que reclama quién es el dueño
+	defense := NodeAnnouncement{Type: "NEW_NODE", ID: msg.ID, NodeID: owner}
+	if owner == self.NodeID {
+		defense.IP, defense.Port = self.IP, self.Port
+	} else if info := self.FindPeerByNodeID(owner); info != nil {
+		defense.IP, defense.Port = info.IP, info.Port
+	}
+	fmt.Printf("⚔️ ID %d ya pertenece a %s, rechazando reclamo de %s\n", msg.ID, owner, msg.NodeID)
+	go sendUDPMessage(defense, msg.IP)
 }
 
-// getNextAvailableID retorna el siguiente ID libre
-func getNextAvailableID() int {
-	max := 0
-	for _, id := range assignedIDs {
-		if id > max {
-			max = id
+// handleNewNode registra un nodo confirmado y resuelve IDs duplicados
+func handleNewNode(self *Peer, msg NodeAnnouncement) {
+	idMutex.Lock()
+	defer idMutex.Unlock()
+
+	// Alguien ya tiene el ID que estamos reclamando
+	if claimingID == msg.ID {
+		claimLost = true
+		recordOwner(msg.ID, msg.NodeID)
+		return
+	}
+
+	// Colisión con nuestro propio ID
+	if self.ID == msg.ID {
+		if self.NodeID < msg.NodeID {
+			fmt.Printf("⚔️ Nodo %s anuncia nuestro ID %d, lo defendemos\n", msg.NodeID, msg.ID)
+			go announceSelf(self)
+			return
 		}
+		fmt.Printf("⚠️ ID %d duplicado con %s, que tiene prioridad; buscando otro\n", msg.ID, msg.NodeID)
+		log.AppendToLocalLog(log.Operation{
+			Type:    "ID_CONFLICT",
+			Time:    time.Now().Unix(),
+			Message: fmt.Sprintf("ID %d cedido a %s", msg.ID, msg.NodeID),
+		})
+		self.ID = 0
+		recordOwner(msg.ID, msg.NodeID)
+		self.SaveState()
+		go ClaimFreeID(self)
+	} else if owner, taken := idOwners[msg.ID]; taken && owner != msg.NodeID {
+		// Duplicado entre otros dos nodos: nos quedamos con el ganador y
+		// el perdedor se reanunciará con otro ID
+		fmt.Printf("⚠️ ID %d duplicado entre %s y %s\n", msg.ID, owner, msg.NodeID)
+		if owner < msg.NodeID {
+			return
+		}
+		self.RemovePeerByNodeID(owner)
+	}
+
+	if old, ok := assignedIDs[msg.NodeID]; !ok || old != msg.ID {
+		fmt.Printf("📢 Nodo %s registrado con ID %d\n", net.JoinHostPort(msg.IP, msg.Port), msg.ID)
+	}
+	recordOwner(msg.ID, msg.NodeID)
+
+	// Agregar a lista de peers locales
+	if msg.IP != "" {
+		info := PeerInfo{ID: msg.ID, NodeID: msg.NodeID, IP: msg.IP, Port: msg.Port}
+		self.AddPeer(info)
+		self.Membership().Join(info)
+		self.SaveState()
+	}
+}
+
+// recordOwner registra que id pertenece a nodeID; el llamador debe tener idMutex
+func recordOwner(id int, nodeID string) {
+	if old, ok := assignedIDs[nodeID]; ok && old != id && idOwners[old] == nodeID {
+		delete(idOwners, old)
 	}
-	if max >= nextID {
-		return max + 1
+	idOwners[id] = nodeID
+	assignedIDs[nodeID] = id
+	if id >= nextID {
+		nextID = id + 1
+	}
+}
+
+// announceSelf difunde el NEW_NODE del nodo local
+func announceSelf(self *Peer) {
+	BroadcastNewNode(NodeAnnouncement{
+		Type:   "NEW_NODE",
+		IP:     self.IP,
+		Port:   self.Port,
+		ID:     self.ID,
+		NodeID: self.NodeID,
+	})
+}
+
+// getNextAvailableID retorna el siguiente ID libre; el llamador debe tener idMutex
+func getNextAvailableID() int {
+	id := nextID
+	for {
+		if _, taken := idOwners[id]; !taken {
+			return id
+		}
+		id++
 	}
-	return nextID
 }
 
 // sendUDPMessage envía un mensaje UDP directo a una IP
@@ -118,8 +306,7 @@ func sendUDPMessage(msg NodeAnnouncement, ip string) {
 	}
 	defer conn.Close()
 
-	data, _ := json.Marshal(msg)
-	conn.Write(data)
+	conn.Write(encodeAnnouncement(msg))
 }
 
 // BroadcastNewNode difunde un NEW_NODE por broadcast UDP
@@ -135,24 +322,5 @@ func BroadcastNewNode(msg NodeAnnouncement) {
 	}
 	defer conn.Close()
 
-	data, _ := json.Marshal(msg)
-	conn.Write(data)
-}
-
-// Utilidades
-
-func mustParsePort(p string) int {
-	port, err := strconv.Atoi(p)
-	if err != nil {
-		return 48999
-	}
-	return port
-}
-
-func getEnvOrDefault(key, fallback string) string {
-	if value, ok := os.LookupEnv(key); ok {
-		return value
-	}
-	return fallback
+	conn.Write(encodeAnnouncement(msg))
 }
-
@@ -0,0 +1,564 @@
+package peer
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"p2pfs/internal/message"
+)
+
+// Membresía al estilo SWIM: en cada ronda se sondea a un miembro con PING;
+// si no responde se pide a otros K miembros que lo sondeen (PING_REQ) y,
+// si tampoco, pasa a sospechoso. Un sospechoso que no refuta a tiempo se da
+// por muerto. Los cambios viajan "a caballo" en PING/PING_REQ/ACK y cada
+// nodo refuta las sospechas sobre sí mismo subiendo su encarnación.
+//
+// Es la única vista de qué nodos están vivos: GUI, difusión y reintentos
+// la consultan en lugar de abrir conexiones de prueba.
+
+const (
+	ProbeInterval  = 1 * time.Second // Un sondeo por ronda
+	PingTimeout    = 1 * time.Second // Espera del ACK directo
+	IndirectProbes = 3               // Miembros a los que se pide PING_REQ
+	SuspectTimeout = 6 * time.Second // Sospechoso → muerto si no refuta
+	MaxGossip      = 8               // Actualizaciones por mensaje
+)
+
+// MemberState es el estado de un miembro según el detector de fallos
+type MemberState string
+
+const (
+	StateAlive   MemberState = "alive"
+	StateSuspect MemberState = "suspect"
+	StateDead    MemberState = "dead"
+	StateUnknown MemberState = "unknown"
+)
+
+// Member es un nodo de la vista de membresía
+type Member struct {
+	PeerInfo
+	State       MemberState
+	Incarnation uint64
+	Changed     time.Time // Último cambio de estado
+}
+
+// gossipItem es una actualización pendiente de difundir
+type gossipItem struct {
+	update message.MemberUpdate
+	sends  int
+}
+
+// Membership es la vista de membresía del nodo local
+type Membership struct {
+	self *Peer
+
+	mu          sync.Mutex
+	members     map[string]*Member // NodeID → miembro
+	incarnation uint64             // Encarnación propia
+	queue       []*gossipItem
+	probeOrder  []string // Orden aleatorio de sondeo de la ronda actual
+
+	detector *phiDetector // Nivel de sospecha por latidos (ver phi.go)
+}
+
+func newMembership(self *Peer) *Membership {
+	return &Membership{
+		self:    self,
+		members: make(map[string]*Member),
+		// Arrancar con la hora para que tras un reinicio la encarnación
+		// supere a la que los demás recuerdan del nodo muerto
+		incarnation: uint64(time.Now().Unix()),
+		detector:    newPhiDetector(),
+	}
+}
+
+// Membership retorna la vista de membresía del nodo (se crea al primer uso)
+func (p *Peer) Membership() *Membership {
+	p.membershipOnce.Do(func() {
+		p.membership = newMembership(p)
+	})
+	return p.membership
+}
+
+// StartMembership ejecuta el protocolo de sondeo; no retorna
+func (p *Peer) StartMembership() {
+	m := p.Membership()
+	for _, info := range p.Peers() {
+		m.Join(info)
+	}
+	go p.adoptLegacyPeers()
+	p.startRing()
+
+	ticker := time.NewTicker(ProbeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.expireSuspects()
+		if target := m.nextProbeTarget(); target != nil {
+			m.probe(*target)
+		}
+	}
+}
+
+// LegacyRetryInterval es cada cuánto se vuelve a intentar identificar a
+// los peers guardados sin NodeID
+const LegacyRetryInterval = 30 * time.Second
+
+// adoptLegacyPeers identifica con un PING directo a los peers guardados sin
+// NodeID (peers.json de versiones anteriores). Sin NodeID no pueden entrar
+// en la vista de membresía y se darían siempre por caídos. Reintenta hasta
+// identificarlos a todos; la identidad sale de la firma del ACK.
+func (p *Peer) adoptLegacyPeers() {
+	for {
+		pending, adopted := 0, 0
+		for _, info := range p.Peers() {
+			if info.NodeID != "" {
+				continue
+			}
+			nodeID, err := p.identify(net.JoinHostPort(info.IP, info.Port))
+			if err != nil {
+				pending++
+				continue
+			}
+			info.NodeID = nodeID
+			p.AddPeer(info)
+			p.Membership().Join(info)
+			adopted++
+			fmt.Printf("🪪 Peer %s:%s identificado como %s\n", info.IP, info.Port, nodeID)
+		}
+		if adopted > 0 && p.ID != 0 {
+			p.SaveState()
+		}
+		if pending == 0 {
+			return
+		}
+		time.Sleep(LegacyRetryInterval)
+	}
+}
+
+// identify obtiene el NodeID de quien escucha en addr
+func (p *Peer) identify(addr string) (string, error) {
+	conn, err := p.dial(addr, PingTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(PingTimeout))
+
+	if err := message.WriteMessage(conn, message.Message{Type: "PING", Origin: p.ID}); err != nil {
+		return "", err
+	}
+	resp, err := message.ReadMessage(conn)
+	if err != nil {
+		return "", err
+	}
+	if resp.Type != "ACK" || resp.NodeID == "" || resp.NodeID == p.NodeID {
+		return "", fmt.Errorf("%s no se identificó", addr)
+	}
+	return resp.NodeID, nil
+}
+
+// Join agrega un nodo recién anunciado (NEW_NODE, REJOIN, peers guardados).
+// Si ya se conocía solo actualiza su dirección e ID: volver de muerto
+// requiere que el propio nodo refute con una encarnación mayor.
+func (m *Membership) Join(info PeerInfo) {
+	if info.NodeID == "" || info.NodeID == m.self.NodeID {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if mem, ok := m.members[info.NodeID]; ok {
+		mem.PeerInfo = info
+		return
+	}
+	m.members[info.NodeID] = &Member{PeerInfo: info, State: StateAlive, Changed: time.Now()}
+	m.self.scheduleRebalance()
+	m.enqueue(message.MemberUpdate{
+		NodeID: info.NodeID, ID: info.ID, IP: info.IP, Port: info.Port,
+		State: string(StateAlive),
+	})
+}
+
+// Members retorna una copia de la vista actual
+func (m *Membership) Members() []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]Member, 0, len(m.members))
+	for _, mem := range m.members {
+		list = append(list, *mem)
+	}
+	return list
+}
+
+// State retorna el estado de un miembro por NodeID o por IP:puerto
+func (m *Membership) State(info PeerInfo) MemberState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if mem := m.lookup(info); mem != nil {
+		return mem.State
+	}
+	return StateUnknown
+}
+
+// lookup busca un miembro; el llamador debe tener mu
+func (m *Membership) lookup(info PeerInfo) *Member {
+	if mem, ok := m.members[info.NodeID]; ok {
+		return mem
+	}
+	for _, mem := range m.members {
+		if mem.IP == info.IP && mem.Port == info.Port {
+			return mem
+		}
+	}
+	return nil
+}
+
+// Phi retorna el nivel de sospecha φ de un miembro: 0 si aún no hay
+// latidos suyos, +Inf si la membresía ya lo dio por caído o no lo conoce
+func (m *Membership) Phi(info PeerInfo) float64 {
+	m.mu.Lock()
+	mem := m.lookup(info)
+	if mem == nil || mem.State == StateDead {
+		m.mu.Unlock()
+		return math.Inf(1)
+	}
+	nodeID := mem.NodeID
+	m.mu.Unlock()
+
+	phi, _ := m.detector.phi(nodeID, time.Now())
+	return phi
+}
+
+// heartbeat registra un latido de un miembro conocido
+func (m *Membership) heartbeat(nodeID string) {
+	m.mu.Lock()
+	_, known := m.members[nodeID]
+	m.mu.Unlock()
+	if known {
+		m.detector.heartbeat(nodeID, time.Now())
+	}
+}
+
+// Phi retorna el nivel de sospecha de un peer (ver Membership.Phi)
+func (p *Peer) Phi(info PeerInfo) float64 {
+	return p.Membership().Phi(info)
+}
+
+// IsAliveAt indica si un peer se considera vivo con el umbral de φ dado.
+// Umbrales bajos detectan antes las caídas a costa de más falsos positivos.
+func (p *Peer) IsAliveAt(info PeerInfo, threshold float64) bool {
+	return p.Phi(info) < threshold
+}
+
+// IsAlive indica si un peer está vivo con el umbral por defecto. Los
+// sospechosos cuentan como vivos mientras φ no pase el umbral.
+func (p *Peer) IsAlive(info PeerInfo) bool {
+	return p.IsAliveAt(info, DefaultPhiThreshold)
+}
+
+// LivePeers retorna los peers conocidos que no están muertos
+func (p *Peer) LivePeers() []PeerInfo {
+	var live []PeerInfo
+	for _, info := range p.Peers() {
+		if p.IsAlive(info) {
+			live = append(live, info)
+		}
+	}
+	return live
+}
+
+// nextProbeTarget elige el siguiente miembro en orden aleatorio por rondas.
+// Los muertos también se sondean para detectar cuándo vuelven.
+func (m *Membership) nextProbeTarget() *Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for len(m.probeOrder) > 0 || len(m.members) > 0 {
+		if len(m.probeOrder) == 0 {
+			for id := range m.members {
+				m.probeOrder = append(m.probeOrder, id)
+			}
+			rand.Shuffle(len(m.probeOrder), func(i, j int) {
+				m.probeOrder[i], m.probeOrder[j] = m.probeOrder[j], m.probeOrder[i]
+			})
+		}
+		id := m.probeOrder[0]
+		m.probeOrder = m.probeOrder[1:]
+		if mem, ok := m.members[id]; ok {
+			target := *mem
+			return &target
+		}
+	}
+	return nil
+}
+
+// probe sondea a target directa y, si hace falta, indirectamente
+func (m *Membership) probe(target Member) {
+	addr := net.JoinHostPort(target.IP, target.Port)
+	if m.ping(addr, PingTimeout, &target) == nil {
+		return
+	}
+	if target.State == StateDead {
+		return // Sigue caído; no vale la pena molestar a otros
+	}
+
+	helpers := m.randomMembers(IndirectProbes, target.NodeID)
+	acked := make(chan bool, len(helpers))
+	for _, h := range helpers {
+		go func(h Member) {
+			acked <- m.pingReq(net.JoinHostPort(h.IP, h.Port), addr) == nil
+		}(h)
+	}
+	for range helpers {
+		if <-acked {
+			m.heartbeat(target.NodeID)
+			return
+		}
+	}
+
+	m.suspect(target.NodeID, target.Incarnation)
+}
+
+// ping envía un PING y procesa el ACK. Si target no figura como vivo se le
+// cuenta lo que creemos de él para que pueda refutarlo.
+func (m *Membership) ping(addr string, timeout time.Duration, target *Member) error {
+	msg := message.Message{Type: "PING", Gossip: m.gossip()}
+	if target != nil && target.State != StateAlive {
+		msg.Gossip = append(msg.Gossip, message.MemberUpdate{
+			NodeID: target.NodeID, ID: target.ID, IP: target.IP, Port: target.Port,
+			State: string(target.State), Incarnation: target.Incarnation,
+		})
+	}
+	return m.exchange(addr, msg, timeout)
+}
+
+// pingReq pide a helper que sondee a addr
+func (m *Membership) pingReq(helper, addr string) error {
+	msg := message.Message{Type: "PING_REQ", Probe: addr, Gossip: m.gossip()}
+	return m.exchange(helper, msg, 2*PingTimeout)
+}
+
+// exchange envía msg y espera un ACK antes de timeout
+func (m *Membership) exchange(addr string, msg message.Message, timeout time.Duration) error {
+	conn, err := m.self.dial(addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	msg.Origin = m.self.ID
+	if err := message.WriteMessage(conn, msg); err != nil {
+		return err
+	}
+	resp, err := message.ReadMessage(conn)
+	if err != nil {
+		return err
+	}
+	if resp.Type != "ACK" {
+		return fmt.Errorf("respuesta inesperada a %s: %s", msg.Type, resp.Type)
+	}
+	m.heartbeat(resp.NodeID)
+	m.apply(resp.Gossip)
+	return nil
+}
+
+// handlePing responde a un PING con ACK
+func (p *Peer) handlePing(conn net.Conn, msg message.Message) {
+	m := p.Membership()
+	m.apply(msg.Gossip)
+	message.WriteMessage(conn, message.Message{Type: "ACK", Origin: p.ID, Gossip: m.gossip()})
+}
+
+// handlePingReq sondea al miembro pedido y responde ACK solo si contestó
+func (p *Peer) handlePingReq(conn net.Conn, msg message.Message) {
+	m := p.Membership()
+	m.apply(msg.Gossip)
+	if err := m.ping(msg.Probe, PingTimeout, nil); err != nil {
+		return
+	}
+	message.WriteMessage(conn, message.Message{Type: "ACK", Origin: p.ID, Gossip: m.gossip()})
+}
+
+// randomMembers elige hasta n miembros vivos distintos de exclude
+func (m *Membership) randomMembers(n int, exclude string) []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var candidates []Member
+	for id, mem := range m.members {
+		if id != exclude && mem.State == StateAlive {
+			candidates = append(candidates, *mem)
+		}
+	}
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	return candidates
+}
+
+// suspect marca a un miembro como sospechoso si sigue en la encarnación sondeada
+func (m *Membership) suspect(nodeID string, incarnation uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mem, ok := m.members[nodeID]
+	if !ok || mem.State != StateAlive || mem.Incarnation != incarnation {
+		return
+	}
+	m.setState(mem, StateSuspect, incarnation)
+}
+
+// expireSuspects da por muertos a los sospechosos que no refutaron a tiempo
+// y cuyo φ confirma la caída; un sondeo lento no basta para matar a nadie
+func (m *Membership) expireSuspects() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for _, mem := range m.members {
+		if mem.State != StateSuspect || now.Sub(mem.Changed) <= SuspectTimeout {
+			continue
+		}
+		if phi, ok := m.detector.phi(mem.NodeID, now); ok && phi < DefaultPhiThreshold {
+			continue
+		}
+		m.setState(mem, StateDead, mem.Incarnation)
+	}
+}
+
+// setState cambia el estado de un miembro y difunde el cambio; el llamador debe tener mu
+func (m *Membership) setState(mem *Member, state MemberState, incarnation uint64) {
+	if mem.State != state {
+		fmt.Printf("👥 Nodo %d (%s) %s → %s\n", mem.ID, mem.NodeID, mem.State, state)
+	}
+	if (mem.State == StateDead) != (state == StateDead) {
+		// El nodo entra o sale del anillo de reparto
+		m.self.scheduleRebalance()
+	}
+	if state == StateDead && mem.State != StateDead {
+		// Sus archivos quedan con menos réplicas
+		m.self.requestRepair()
+	}
+	if mem.State == StateDead && state == StateAlive {
+		// El hueco de la caída no es un intervalo normal entre latidos
+		m.detector.forget(mem.NodeID)
+		go m.self.onReconnect(mem.PeerInfo)
+	}
+	mem.State = state
+	mem.Incarnation = incarnation
+	mem.Changed = time.Now()
+	m.enqueue(message.MemberUpdate{
+		NodeID: mem.NodeID, ID: mem.ID, IP: mem.IP, Port: mem.Port,
+		State: string(state), Incarnation: incarnation,
+	})
+}
+
+// apply incorpora actualizaciones recibidas por gossip
+func (m *Membership) apply(updates []message.MemberUpdate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range updates {
+		if u.NodeID == "" {
+			continue
+		}
+		state := MemberState(u.State)
+
+		// Rumores sobre nosotros: refutar subiendo la encarnación
+		if u.NodeID == m.self.NodeID {
+			if state != StateAlive && u.Incarnation >= m.incarnation {
+				m.incarnation = u.Incarnation + 1
+				fmt.Printf("🛡️ Refutando estado %s sobre este nodo (encarnación %d)\n", state, m.incarnation)
+			}
+			continue
+		}
+
+		mem, known := m.members[u.NodeID]
+		if !known {
+			if state == StateDead {
+				continue
+			}
+			mem = &Member{
+				PeerInfo:    PeerInfo{ID: u.ID, NodeID: u.NodeID, IP: u.IP, Port: u.Port},
+				State:       state,
+				Incarnation: u.Incarnation,
+				Changed:     time.Now(),
+			}
+			m.members[u.NodeID] = mem
+			m.enqueue(u)
+			m.self.AddPeer(mem.PeerInfo)
+			fmt.Printf("👥 Nodo %d (%s) conocido por gossip\n", u.ID, u.NodeID)
+			continue
+		}
+
+		// Reglas de precedencia de SWIM
+		var accept bool
+		switch state {
+		case StateAlive:
+			accept = u.Incarnation > mem.Incarnation
+		case StateSuspect:
+			accept = (mem.State == StateAlive && u.Incarnation >= mem.Incarnation) ||
+				u.Incarnation > mem.Incarnation
+		case StateDead:
+			accept = mem.State != StateDead && u.Incarnation >= mem.Incarnation
+		}
+		if !accept {
+			continue
+		}
+		if u.IP != "" && (u.IP != mem.IP || u.Port != mem.Port || u.ID != mem.ID) {
+			mem.ID, mem.IP, mem.Port = u.ID, u.IP, u.Port
+			m.self.AddPeer(mem.PeerInfo)
+		}
+		m.setState(mem, state, u.Incarnation)
+	}
+}
+
+// enqueue agrega una actualización a difundir, reemplazando la anterior
+// del mismo nodo; el llamador debe tener mu
+func (m *Membership) enqueue(u message.MemberUpdate) {
+	for i, item := range m.queue {
+		if item.update.NodeID == u.NodeID {
+			m.queue = append(m.queue[:i], m.queue[i+1:]...)
+			break
+		}
+	}
+	m.queue = append(m.queue, &gossipItem{update: u})
+}
+
+// gossip arma las actualizaciones a enviar en el próximo mensaje: nuestro
+// propio estado más las menos difundidas. Cada una se reenvía unas
+// λ·log(n) veces y luego se descarta.
+func (m *Membership) gossip() []message.MemberUpdate {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	updates := []message.MemberUpdate{{
+		NodeID:      m.self.NodeID,
+		ID:          m.self.ID,
+		IP:          m.self.IP,
+		Port:        m.self.Port,
+		State:       string(StateAlive),
+		Incarnation: m.incarnation,
+	}}
+
+	limit := 3 * int(math.Ceil(math.Log2(float64(len(m.members)+2))))
+	sort.SliceStable(m.queue, func(i, j int) bool { return m.queue[i].sends < m.queue[j].sends })
+	kept := m.queue[:0]
+	for _, item := range m.queue {
+		if len(updates) < MaxGossip {
+			updates = append(updates, item.update)
+			item.sends++
+		}
+		if item.sends < limit {
+			kept = append(kept, item)
+		}
+	}
+	m.queue = kept
+	return updates
+}
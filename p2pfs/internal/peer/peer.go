@@ -1,60 +1,116 @@
 package peer
 
 import (
-	"bufio"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
 	"os"
 	"path/filepath"
 	"strings"
-  "p2pfs/internal/utils"
-  logger "p2pfs/internal/log"
-
+	"sync"
+	"time"
+
+	"p2pfs/internal/fs"
+	"p2pfs/internal/identity"
+	logger "p2pfs/internal/log"
+	"p2pfs/internal/message"
+	"p2pfs/internal/store"
+	"p2pfs/internal/utils"
 )
 
 // PeerInfo representa a un nodo en la red
 type PeerInfo struct {
-	ID   int
-	IP   string
-	Port string
+	ID     int
+	NodeID string // Identidad derivada de la clave pública del nodo
+	IP     string
+	Port   string
 }
 
 // Peer representa al nodo local (self)
 type Peer struct {
-	ID      int         // ID del nodo local (0 si aún no asignado)
-	IP      string      // IP local detectada
-	Port    string      // Puerto en el que escucha este nodo
-	Peers   []PeerInfo  // Lista de peers conocidos
-	Conn    net.Conn    // Conexión TCP activa (si aplica)
+	ID     int      // ID del nodo local (0 si aún no asignado)
+	NodeID string   // Identidad criptográfica (identity.Current.NodeID)
+	IP     string   // IP local detectada
+	Port   string   // Puerto en el que escucha este nodo
+	Conn   net.Conn // Conexión TCP activa (si aplica)
+
+	// Peers conocidos; se leen con Peers() y se cambian con AddPeer y
+	// RemovePeerByNodeID, que se llaman desde goroutines de red
+	peers   []PeerInfo
+	peersMu sync.RWMutex
 
 	// Control de estado de descubrimiento
-	LastHelloSent   time.Time // Último broadcast HELLO emitido
-	LastIDAssigned  time.Time // Último momento en que recibió o asignó un ID
+	LastHelloSent  time.Time // Último broadcast HELLO emitido
+	LastIDAssigned time.Time // Último momento en que recibió o asignó un ID
+
+	// TLS mutuo entre nodos (nil = TCP en claro)
+	ServerTLS *tls.Config // Listener: exige certificado firmado por la CA
+	ClientTLS *tls.Config // Conexiones salientes: presenta el certificado propio
+
+	// Vista de membresía (ver membership.go)
+	membership     *Membership
+	membershipOnce sync.Once
+
+	// Reparto de archivos en el anillo (ver ring.go)
+	ringMu      sync.Mutex
+	ringMembers []PeerInfo  // Miembros del anillo en el último reparto
+	rebalancer  *time.Timer // Agrupa cambios de membresía seguidos
+
+	// Pedidos de reparación de réplicas (ver repair.go)
+	repairOnce sync.Once
+	repairCh   chan struct{}
 }
 
 // NewPeer crea un nuevo nodo Peer
 func NewPeer(id int, port string, peers []PeerInfo) *Peer {
 	return &Peer{
 		ID:     id,
+		NodeID: identity.Current.NodeID,
 		IP:     GetLocalIP(),
 		Port:   port,
-		Peers:  peers,
+		peers:  append([]PeerInfo(nil), peers...),
 	}
 }
 
+// Peers retorna una copia de la lista de peers conocidos
+func (p *Peer) Peers() []PeerInfo {
+	p.peersMu.RLock()
+	defer p.peersMu.RUnlock()
+	return append([]PeerInfo(nil), p.peers...)
+}
+
+// AddPeer agrega un peer o actualiza su ID si ya se conocía su dirección
 func (p *Peer) AddPeer(info PeerInfo) {
-	for _, existing := range p.Peers {
+	p.peersMu.Lock()
+	defer p.peersMu.Unlock()
+	for i, existing := range p.peers {
 		if existing.IP == info.IP && existing.Port == info.Port {
-			return // ya está
+			p.peers[i].ID = info.ID
+			if info.NodeID != "" {
+				p.peers[i].NodeID = info.NodeID
+			}
+			return
 		}
 	}
-	p.Peers = append(p.Peers, info)
+	p.peers = append(p.peers, info)
 }
 
+// RemovePeerByNodeID quita de la lista el peer con esa identidad
+func (p *Peer) RemovePeerByNodeID(nodeID string) {
+	p.peersMu.Lock()
+	defer p.peersMu.Unlock()
+	for i, existing := range p.peers {
+		if existing.NodeID == nodeID {
+			p.peers = append(p.peers[:i], p.peers[i+1:]...)
+			return
+		}
+	}
+}
 
 func (p *Peer) FindPeerByID(id int) *PeerInfo {
-	for _, peer := range p.Peers {
+	for _, peer := range p.Peers() {
 		if peer.ID == id {
 			return &peer
 		}
@@ -62,9 +118,51 @@ func (p *Peer) FindPeerByID(id int) *PeerInfo {
 	return nil
 }
 
+// findPeerByAddr busca un peer por su IP:puerto
+func (p *Peer) findPeerByAddr(addr string) *PeerInfo {
+	for _, peer := range p.Peers() {
+		if net.JoinHostPort(peer.IP, peer.Port) == addr {
+			return &peer
+		}
+	}
+	return nil
+}
+
+// FindPeerByNodeID busca un peer por su identidad criptográfica
+func (p *Peer) FindPeerByNodeID(nodeID string) *PeerInfo {
+	for _, peer := range p.Peers() {
+		if peer.NodeID == nodeID {
+			return &peer
+		}
+	}
+	return nil
+}
+
+// listen abre el listener TCP del nodo, con TLS mutuo si está configurado
+func (p *Peer) listen() (net.Listener, error) {
+	ln, err := net.Listen("tcp", ":"+p.Port)
+	if err != nil || p.ServerTLS == nil {
+		return ln, err
+	}
+	return tls.NewListener(ln, p.ServerTLS), nil
+}
+
+// dial conecta con otro nodo, con TLS mutuo si está configurado
+func (p *Peer) dial(addr string, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	if p.ClientTLS == nil {
+		return dialer.Dial("tcp", addr)
+	}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, p.ClientTLS)
+	if err != nil {
+		return nil, fmt.Errorf("handshake TLS con %s fallido: %w", addr, err)
+	}
+	return conn, nil
+}
+
 // StartListener inicia la escucha para recibir archivos
 func (p *Peer) StartListener() {
-	ln, err := net.Listen("tcp", ":"+p.Port)
+	ln, err := p.listen()
 	if err != nil {
 		fmt.Println("Error al iniciar listener:", err)
 		return
@@ -83,223 +181,206 @@ func (p *Peer) StartListener() {
 	}
 }
 
-// handleConnection recibe y guarda un archivo, verificando hash e intentando descomprimir si es ZIP
-// handleConnection recibe, verifica hash y descomprime ZIPs
-func (p *Peer) handleConnection(conn net.Conn) {
-	defer conn.Close()
+// outgoingFile es un archivo listo para enviar: ya comprimido si era
+// carpeta e importado al almacén de bloques
+type outgoingFile struct {
+	originalPath string // Ruta que pidió el usuario
+	path         string // Archivo a transmitir (el ZIP temporal si era carpeta)
+	filename     string
+	manifest     store.Manifest
+	op           logger.Operation // TRANSFER firmado de la versión que se envía
+	limit        *tokenBucket     // Límite de ancho de banda (nil: sin límite)
+	header       message.Message
+	cleanup      func()
+}
 
-	reader := bufio.NewReader(conn)
+// prepareFile comprime si es carpeta y calcula los bloques y el hash
+func (p *Peer) prepareFile(filePath string) (*outgoingFile, error) {
+	if p.ID == 0 {
+		return nil, fmt.Errorf("nodo sin ID asignado, no se puede enviar archivos")
+	}
 
-	// Leer nombre del archivo
-	filename, err := reader.ReadString('\n')
+	info, err := os.Stat(filePath)
 	if err != nil {
-		fmt.Println("Error al leer nombre del archivo:", err)
-		return
+		return nil, fmt.Errorf("no se pudo acceder al archivo: %v", err)
 	}
-	filename = strings.TrimSpace(filename)
 
-	// Leer hash esperado
-	expectedHash, err := reader.ReadString('\n')
-	if err != nil {
-		fmt.Println("Error al leer hash:", err)
-		return
+	out := &outgoingFile{
+		originalPath: filePath,
+		path:         filePath,
+		filename:     filepath.Base(filePath),
+		cleanup:      func() {},
 	}
-	expectedHash = strings.TrimSpace(expectedHash)
 
-	// Guardar archivo recibido
-	destPath := "shared/" + filename
-	file, err := os.Create(destPath)
-	if err != nil {
-		fmt.Println("Error al crear archivo:", err)
-		return
+	// Si es carpeta, crear ZIP temporal
+	if info.IsDir() {
+		tmpZip := filepath.Join(os.TempDir(), info.Name()+".zip")
+		if err := utils.ZipFolder(filePath, tmpZip); err != nil {
+			return nil, fmt.Errorf("error al comprimir carpeta: %v", err)
+		}
+		out.path = tmpZip
+		out.filename = info.Name() + ".zip"
+		out.cleanup = func() { os.Remove(tmpZip) }
 	}
-	_, err = io.Copy(file, reader)
-	file.Close()
+
+	// Partir en bloques y calcular hashes; el contenido queda en el almacén
+	manifest, err := store.Import(out.path, message.DefaultChunkSize)
 	if err != nil {
-		fmt.Println("Error al guardar archivo:", err)
-		return
+		out.cleanup()
+		return nil, fmt.Errorf("error al calcular hash: %v", err)
 	}
+	out.manifest = manifest
+	if !info.IsDir() && inShared(filePath) {
+		// El archivo de shared/ es la copia de esta versión: sus bloques
+		// no hace falta conservarlos
+		store.Materialize(manifest.Hash, filePath)
+	}
+	out.op = versionOp(out.filename, manifest)
+	out.header = p.transferHeader(manifest, out.filename)
+	out.header.Data, _ = json.Marshal(out.op)
+	return out, nil
+}
 
-	// Registrar transferencia
-	logger.AppendToLocalLog(logger.Operation{
-		Type:      "TRANSFER",
-		FileName:  filename,
-		From:      conn.RemoteAddr().String(),
-		Timestamp: time.Now().Unix(),
-		Message:   "Archivo recibido",
-	})
-
-	// Verificar hash
-	actualHash, err := utils.CalculateSHA256(destPath)
+// inShared indica si path está dentro de shared/
+func inShared(path string) bool {
+	abs, err := filepath.Abs(path)
 	if err != nil {
-		fmt.Println("⚠️ Error al calcular hash:", err)
-		return
+		return false
 	}
-
-	if actualHash == expectedHash {
-		fmt.Println("✅ Hash verificado correctamente")
-
-		logger.AppendToLocalLog(logger.Operation{
-			Type:      "HASH_OK",
-			FileName:  filename,
-			From:      conn.RemoteAddr().String(),
-			Timestamp: time.Now().Unix(),
-			Message:   "SHA256 válido",
-		})
-	} else {
-		fmt.Println("❌ Hash inválido")
-		fmt.Printf("Esperado: %s\nRecibido: %s\n", expectedHash, actualHash)
-
-		logger.AppendToLocalLog(logger.Operation{
-			Type:      "HASH_FAIL",
-			FileName:  filename,
-			From:      conn.RemoteAddr().String(),
-			Timestamp: time.Now().Unix(),
-			Message:   fmt.Sprintf("Esperado: %s, Recibido: %s", expectedHash, actualHash),
-		})
-		return
+	root, err := filepath.Abs("shared")
+	if err != nil {
+		return false
 	}
+	rel, err := filepath.Rel(root, abs)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
 
-	// Si es un ZIP, descomprimir
-	if strings.HasSuffix(filename, ".zip") {
-		fmt.Println("📦 ZIP detectado, descomprimiendo...")
-		err := utils.UnzipFile(destPath, "shared/")
-		if err != nil {
-			fmt.Println("❌ Error al descomprimir:", err)
-
-			logger.AppendToLocalLog(logger.Operation{
-				Type:      "UNZIP_FAIL",
-				FileName:  filename,
-				From:      conn.RemoteAddr().String(),
-				Timestamp: time.Now().Unix(),
-				Message:   err.Error(),
-			})
-			return
-		}
-		os.Remove(destPath)
-		fmt.Println("✅ Descompresión exitosa")
-
-		logger.AppendToLocalLog(logger.Operation{
-			Type:      "UNZIP",
-			FileName:  filename,
-			From:      conn.RemoteAddr().String(),
-			Timestamp: time.Now().Unix(),
-			Message:   "ZIP descomprimido correctamente",
-		})
-	} else {
-		fmt.Println("📥 Archivo recibido como:", filename)
+// versionOp retorna el TRANSFER firmado que describe esta versión del
+// archivo: el último registrado para su ruta si tiene el mismo hash (una
+// réplica que se reenvía) o uno nuevo si el contenido cambió. Viaja en la
+// cabecera para que el receptor sepa de qué historia desciende la copia.
+func versionOp(filename string, m store.Manifest) logger.Operation {
+	target := filepath.Join("shared", filename)
+	if op, ok := fs.Current(target); ok && op.Type == "TRANSFER" && op.Hash == m.Hash {
+		return op
 	}
+	return logger.AppendToLocalLog(logger.Operation{
+		Type:     "TRANSFER",
+		Path:     target,
+		FileName: filename,
+		Hash:     m.Hash,
+		Size:     m.Size,
+		Time:     time.Now().Unix(),
+		Message:  "Nueva versión local",
+	})
 }
 
-// SendFile envía un archivo a un nodo destino
 // SendFile comprime si es carpeta, calcula hash, y envía archivo a otro peer
-// SendFile calcula hash, comprime si es carpeta y envía
 func (p *Peer) SendFile(filePath, addr string) error {
-	const maxRetries = 3
-	const timeout = 5 * time.Second
-  if p.ID == 0 {
-	  return fmt.Errorf("nodo sin ID asignado, no se puede enviar archivos")
-  }
-
-	info, err := os.Stat(filePath)
+	out, err := p.prepareFile(filePath)
 	if err != nil {
-		return fmt.Errorf("no se pudo acceder al archivo: %v", err)
+		return err
 	}
+	defer out.cleanup()
+	return p.sendPrepared(out, addr)
+}
 
-	originalPath := filePath
-	filename := filepath.Base(filePath)
-
-	// Si es carpeta, crear ZIP temporal
-	if info.IsDir() {
-		tmpZip := filepath.Join(os.TempDir(), info.Name()+".zip")
-		if err := utils.ZipFolder(filePath, tmpZip); err != nil {
-			return fmt.Errorf("error al comprimir carpeta: %v", err)
-		}
-		filePath = tmpZip
-		defer os.Remove(tmpZip)
-		filename = info.Name() + ".zip"
-	}
+// sendPrepared envía un archivo ya preparado con reintentos y backoff. No
+// encola nada: de la cola de reintentos se encarga fanOut, que conoce el
+// NodeID del destino; los envíos directos (anti-entropía, re-replicación,
+// read-repair, la propia cola) se repiten en su próxima pasada.
+func (p *Peer) sendPrepared(out *outgoingFile, addr string) error {
+	const maxRetries = 3
+	const timeout = 5 * time.Second
 
-	// Calcular hash
-	hash, err := utils.CalculateSHA256(filePath)
-	if err != nil {
-		return fmt.Errorf("error al calcular hash: %v", err)
-	}
+	originalPath, filePath, filename := out.originalPath, out.path, out.filename
+	manifest, header := out.manifest, out.header
 
 	var lastErr error
 	for attempt := 1; attempt <= maxRetries; attempt++ {
 		fmt.Printf("🔁 Intento %d de %d para enviar %s...\n", attempt, maxRetries, filename)
 
-		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		defer cancel()
-
-		dialer := net.Dialer{}
-		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		conn, err := p.dial(addr, timeout)
 		if err != nil {
 			lastErr = err
 			logger.AppendToLocalLog(logger.Operation{
-				Type:      "SEND_FAIL",
-				FileName:  filename,
-				From:      GetLocalIP() + ":" + p.Port,
-				Timestamp: time.Now().Unix(),
-				Message:   fmt.Sprintf("Conexión fallida (intento %d): %v", attempt, err),
+				Type:     "SEND_FAIL",
+				FileName: filename,
+				From:     GetLocalIP() + ":" + p.Port,
+				Time:     time.Now().Unix(),
+				Message:  fmt.Sprintf("Conexión fallida (intento %d): %v", attempt, err),
 			})
 			time.Sleep(time.Second * time.Duration(attempt)) // Backoff
 			continue
 		}
-		defer conn.Close()
 
 		// Abrir el archivo para cada intento
 		file, err := os.Open(filePath)
 		if err != nil {
 			lastErr = err
+			conn.Close()
 			break
 		}
 
-		// Enviar nombre y hash
-		_, err = fmt.Fprintf(conn, filename+"\n"+hash+"\n")
-		if err != nil {
-			lastErr = err
-			file.Close()
-			continue
+		// Enviar cabecera TRANSFER y solo los bloques (o el delta) que pida
+		// el receptor, y esperar a que confirme la copia
+		var rw io.ReadWriter = conn
+		if out.limit != nil {
+			rw = limitedConn{conn, out.limit}
 		}
-
-		// Enviar contenido del archivo
-		_, err = io.Copy(conn, file)
+		res, err := streamFile(rw, header, fileChunks(file, manifest.Size, manifest.ChunkSize))
 		file.Close()
+		if err == nil {
+			err = awaitAck(conn, header)
+		}
+		conn.Close()
 		if err != nil {
 			lastErr = err
+			logger.AppendToLocalLog(logger.Operation{
+				Type:     "SEND_FAIL",
+				FileName: filename,
+				From:     GetLocalIP() + ":" + p.Port,
+				Time:     time.Now().Unix(),
+				Message:  fmt.Sprintf("Envío fallido (intento %d): %v", attempt, err),
+			})
+			time.Sleep(time.Second * time.Duration(attempt)) // Backoff
 			continue
 		}
+		switch {
+		case res.Delta:
+			fmt.Printf("🧩 Transferencia delta: %d de %d bytes enviados como literales\n", res.Literal, manifest.Size)
+		case res.Sent < len(manifest.Chunks):
+			fmt.Printf("♻️ %d de %d bloques ya estaban en el destino\n", len(manifest.Chunks)-res.Sent, len(manifest.Chunks))
+		}
+		if res.Offset > 0 {
+			fmt.Printf("⏯️ Transferencia reanudada desde el byte %d\n", res.Offset)
+		}
 
-		// Éxito
-		fmt.Printf("📤 Enviado correctamente: %s → %s\n", originalPath, addr)
+		// Éxito: el receptor confirmó el hash
+		fmt.Printf("📤 Enviado y verificado: %s → %s\n", originalPath, addr)
 
+		// La versión ya quedó registrada como TRANSFER (out.op); el envío
+		// es solo un evento local
 		logger.AppendToLocalLog(logger.Operation{
-			Type:      "TRANSFER",
-			FileName:  filename,
-			From:      GetLocalIP() + ":" + p.Port,
-			Timestamp: time.Now().Unix(),
-			Message:   fmt.Sprintf("Archivo enviado exitosamente a %s en el intento %d", addr, attempt),
+			Type:     "SEND_OK",
+			Path:     "shared/" + filename,
+			FileName: filename,
+			From:     GetLocalIP() + ":" + p.Port,
+			Hash:     manifest.Hash,
+			Size:     manifest.Size,
+			Time:     time.Now().Unix(),
+			Message:  fmt.Sprintf("Archivo enviado y verificado por %s en el intento %d", addr, attempt),
 		})
 		return nil
 	}
 
 	// Todos los intentos fallaron
 	logger.AppendToLocalLog(logger.Operation{
-		Type:      "SEND_FAIL",
-		FileName:  filename,
-		From:      GetLocalIP() + ":" + p.Port,
-		Timestamp: time.Now().Unix(),
-		Message:   fmt.Sprintf("Falló tras %d intentos. Último error: %v", maxRetries, lastErr),
-	})
-
-	// Agregar a la cola de reintentos
-	_ = utils.AddPendingTask(utils.PendingTask{
-		Type:     "TRANSFER",
-		FilePath: originalPath,
-		Target:   addr,
-		Retries:  maxRetries,
+		Type:     "SEND_FAIL",
+		FileName: filename,
+		From:     GetLocalIP() + ":" + p.Port,
+		Time:     time.Now().Unix(),
+		Message:  fmt.Sprintf("Falló tras %d intentos. Último error: %v", maxRetries, lastErr),
 	})
 
 	return fmt.Errorf("falló envío tras %d intentos: %v", maxRetries, lastErr)
@@ -321,6 +402,7 @@ func GetLocalIP() string {
 	return "127.0.0.1"
 }
 
+// RetryWorker reintenta periódicamente las tareas de la cola
 func (p *Peer) RetryWorker(interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
@@ -338,68 +420,109 @@ func (p *Peer) RetryWorker(interval time.Duration) {
 
 		fmt.Printf("🔁 Reintentando %d tarea(s) fallidas...\n", len(tasks))
 
-		var updated []utils.PendingTask
 		for _, task := range tasks {
-			if task.Type != "TRANSFER" {
-				updated = append(updated, task) // otros tipos, conservar
-				continue
-			}
+			p.retryTask(task)
+		}
+	}
+}
+
+// retryTask reintenta una tarea y actualiza la cola con el resultado
+func (p *Peer) retryTask(task utils.PendingTask) {
+	if task.Type != "TRANSFER" && task.Type != "DELETE" {
+		return // otros tipos, conservar
+	}
 
-			// Reintentar
-			err := p.SendFile(task.FilePath, task.Target)
-			if err != nil {
-				// No se pudo completar, mantener en la cola
-				task.Retries += 1
-				updated = append(updated, task)
+	// El archivo se borró en el clúster mientras esperaba: no resucitarlo
+	if task.Type == "TRANSFER" {
+		if _, err := os.Stat(task.FilePath); os.IsNotExist(err) {
+			if _, ok := fs.TombstoneFor(task.FilePath); ok {
+				fmt.Printf("🪦 %s fue borrado, se descarta su reintento\n", task.FilePath)
+				utils.RemovePendingTask(task)
+				return
 			}
 		}
+	}
 
-		// Reescribir la cola
-		if err := utils.SaveRetryQueue(updated); err != nil {
-			fmt.Println("⚠️ Error al guardar cola de reintentos:", err)
+	// El DELETE a reenviar es el último registrado para la ruta; si el
+	// archivo volvió a crearse desde entonces, el borrado ya no aplica
+	var del logger.Operation
+	if task.Type == "DELETE" {
+		op, ok := latestDelete(task.FilePath)
+		if _, err := os.Stat(task.FilePath); !ok || err == nil {
+			utils.RemovePendingTask(task)
+			return
 		}
+		del = op
 	}
-}
 
-func (p *Peer) RequestFileTree(addr string) (*fs.FileNode, error) {
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		return nil, err
+	// Resolver la dirección actual del destino a partir de su identidad
+	addr := task.Target
+	target := p.findPeerByAddr(task.Target)
+	if task.TargetID != "" {
+		target = p.FindPeerByNodeID(task.TargetID)
+		if target == nil {
+			return // Aún no sabemos dónde está
+		}
+		addr = net.JoinHostPort(target.IP, target.Port)
+	}
+
+	// Tareas antiguas solo con IP:puerto: pasar a identificar al destino por NodeID
+	if task.TargetID == "" && target != nil && target.NodeID != "" {
+		utils.RemovePendingTask(task)
+		task.TargetID = target.NodeID
+		utils.AddPendingTask(task)
 	}
-	defer conn.Close()
 
-	msg := message.Message{
-		Type: "LIST",
-		From: p.ID,
+	// Si la membresía da al destino por caído, esperar a que vuelva
+	if target != nil && !p.IsAlive(*target) {
+		return
 	}
-	data, _ := json.Marshal(msg)
-	conn.Write(data)
 
-	response, err := io.ReadAll(conn)
+	var err error
+	if task.Type == "DELETE" {
+		err = p.sendDelete(del, addr)
+	} else {
+		err = p.SendFile(task.FilePath, addr)
+	}
 	if err != nil {
-		return nil, err
+		// No se pudo completar, mantener en la cola
+		utils.RecordRetryFailure(task)
+		return
 	}
+	utils.RemovePendingTask(task)
+}
 
-	var resp message.Message
-	if err := json.Unmarshal(response, &resp); err != nil {
+// RequestFileTree solicita a otro nodo el árbol de archivos de su shared/
+func (p *Peer) RequestFileTree(addr string) (*fs.FileNode, error) {
+	resp, err := p.request(addr, message.Message{
+		Type:   "LIST",
+		Origin: p.ID,
+	})
+	if err != nil {
 		return nil, err
 	}
-
+	if resp.FileTree == nil {
+		return nil, fmt.Errorf("árbol de %s no disponible: %s", addr, resp.Reason)
+	}
 	return resp.FileTree, nil
 }
 
-func (p *Peer) handleList(conn net.Conn) {
-	tree, err := fs.BuildFileTree("shared")
+// RequestTimeout acota una consulta completa (envío y respuesta): un peer
+// que no contesta no deja colgado a quien pregunta, sea la GUI o un bucle
+// de fondo
+const RequestTimeout = 10 * time.Second
+
+// request envía un mensaje a addr y espera una trama de respuesta
+func (p *Peer) request(addr string, msg message.Message) (message.Message, error) {
+	conn, err := p.dial(addr, 5*time.Second)
 	if err != nil {
-		return
+		return message.Message{}, err
 	}
-	resp := message.Message{
-		Type:     "LIST",
-		From:     p.ID,
-		FileTree: &tree,
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(RequestTimeout))
+
+	if err := message.WriteMessage(conn, msg); err != nil {
+		return message.Message{}, err
 	}
-	data, _ := json.Marshal(resp)
-	conn.Write(data)
+	return message.ReadMessage(conn)
 }
-
-
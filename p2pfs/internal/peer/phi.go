@@ -0,0 +1,118 @@
+package peer
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Detector de fallos phi-accrual (Hayashibara et al.): en lugar de decidir
+// vivo/muerto, cada peer tiene un nivel de sospecha φ calculado a partir
+// del historial de intervalos entre latidos. φ = 1 equivale a un 10% de
+// probabilidad de equivocarse al darlo por caído, φ = 2 a un 1%, etc.
+// Cada llamador elige el umbral que le conviene.
+//
+// Un latido es cualquier evidencia directa de que el nodo responde: un ACK
+// a nuestros PING (directo o indirecto) o un mensaje recibido de él.
+
+const (
+	// DefaultPhiThreshold es el umbral de IsAlive y de la confirmación de caídas
+	DefaultPhiThreshold = 8.0
+
+	phiWindowSize    = 100                    // Intervalos recordados por peer
+	phiFirstEstimate = 2 * time.Second        // Intervalo supuesto antes de tener historia
+	phiMinStdDev     = 200 * time.Millisecond // Evita φ desorbitado con latidos muy regulares
+	// phiAcceptablePause tolera retrasos puntuales en la red del laboratorio
+	phiAcceptablePause = 3 * time.Second
+)
+
+// arrivalWindow guarda los últimos intervalos entre latidos de un peer
+type arrivalWindow struct {
+	intervals []float64 // en milisegundos
+	next      int       // Posición a reemplazar cuando la ventana está llena
+	last      time.Time
+}
+
+// phiDetector lleva el historial de latidos de todos los peers (por NodeID)
+type phiDetector struct {
+	mu      sync.Mutex
+	windows map[string]*arrivalWindow
+}
+
+func newPhiDetector() *phiDetector {
+	return &phiDetector{windows: make(map[string]*arrivalWindow)}
+}
+
+// heartbeat registra un latido de nodeID en el instante now
+func (d *phiDetector) heartbeat(nodeID string, now time.Time) {
+	if nodeID == "" {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	w, ok := d.windows[nodeID]
+	if !ok {
+		// Sembrar con la estimación inicial para que el primer φ sea razonable
+		est := float64(phiFirstEstimate.Milliseconds())
+		d.windows[nodeID] = &arrivalWindow{
+			intervals: []float64{est - est/4, est + est/4},
+			last:      now,
+		}
+		return
+	}
+
+	interval := float64(now.Sub(w.last).Milliseconds())
+	w.last = now
+	if len(w.intervals) < phiWindowSize {
+		w.intervals = append(w.intervals, interval)
+		return
+	}
+	w.intervals[w.next] = interval
+	w.next = (w.next + 1) % phiWindowSize
+}
+
+// phi retorna el nivel de sospecha de nodeID en now. Sin latidos retorna
+// (0, false): no hay evidencia en ningún sentido.
+func (d *phiDetector) phi(nodeID string, now time.Time) (float64, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	w, ok := d.windows[nodeID]
+	if !ok {
+		return 0, false
+	}
+
+	var sum, sumSq float64
+	for _, v := range w.intervals {
+		sum += v
+		sumSq += v * v
+	}
+	n := float64(len(w.intervals))
+	mean := sum / n
+	stdDev := math.Sqrt(math.Max(sumSq/n-mean*mean, 0))
+	stdDev = math.Max(stdDev, float64(phiMinStdDev.Milliseconds()))
+	mean += float64(phiAcceptablePause.Milliseconds())
+
+	elapsed := float64(now.Sub(w.last).Milliseconds())
+	return phiOf(elapsed, mean, stdDev), true
+}
+
+// forget descarta el historial de un peer (p. ej. tras reiniciarse)
+func (d *phiDetector) forget(nodeID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.windows, nodeID)
+}
+
+// phiOf calcula φ = -log10(1 - F(elapsed)) con F la distribución normal
+// acumulada, usando la aproximación logística de Akka para evitar
+// desbordamientos en la cola
+func phiOf(elapsed, mean, stdDev float64) float64 {
+	y := (elapsed - mean) / stdDev
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+	if elapsed > mean {
+		return -math.Log10(e / (1 + e))
+	}
+	return -math.Log10(1 - 1/(1+e))
+}
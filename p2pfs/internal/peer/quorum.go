@@ -0,0 +1,283 @@
+package peer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"time"
+
+	"p2pfs/internal/config"
+	"p2pfs/internal/fs"
+	"p2pfs/internal/log"
+	"p2pfs/internal/message"
+	"p2pfs/internal/store"
+)
+
+// Quórum: un envío desde la GUI solo se da por bueno cuando
+// config.Current.Quorum.Write réplicas tienen una copia con el hash
+// correcto, y la versión de un archivo se lee de Quorum.Read réplicas
+// quedándose con la más nueva. Las réplicas consultadas que estaban
+// desactualizadas se reparan en el momento (read-repair).
+//
+// Un envío cuenta cuando el receptor lo confirmó con TRANSFER_ACK, que
+// manda después de verificar el hash. Para leer, cada réplica informa su
+// versión con STAT: el nodo del árbol de archivos (hash, tamaño, fecha) y
+// la última operación que conoce para la ruta. Este nodo cuenta como
+// réplica si es dueño del archivo y su copia tiene el hash escrito.
+// config.Load ya exige W + R > N; si hay menos réplicas que el quórum
+// pedido (con factor 0, un clúster chico) la operación falla.
+
+// FileVersion es la versión de un archivo en una réplica
+type FileVersion struct {
+	Peer   PeerInfo
+	Exists bool
+	IsDir  bool
+	Hash   string
+	Size   int64
+	Mod    time.Time
+	Op     *log.Operation // Última operación registrada para la ruta, si hay
+}
+
+// QuorumWrite envía filePath a sus dueños como BroadcastFile. Las copias
+// que el receptor no confirmó ya quedaron en la cola de reintentos.
+// Retorna error si menos de Quorum.Write réplicas quedaron verificadas.
+func (p *Peer) QuorumWrite(filePath string) ([]TransferResult, error) {
+	need, err := p.quorumSize(config.Current.Quorum.Write, "escritura")
+	if err != nil {
+		return nil, err
+	}
+	written, err := fs.BuildFileTree(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("no se pudo acceder al archivo: %v", err)
+	}
+	results, err := p.BroadcastFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Este nodo solo cuenta si es dueño y su copia es la que se escribió
+	confirmed := 0
+	if local := localVersion(filePath); p.Holds(filePath) && local.Exists && local.Hash == written.Hash {
+		confirmed++
+	}
+	for _, r := range results {
+		if r.Err == nil {
+			confirmed++
+		}
+	}
+
+	if confirmed < need {
+		return results, fmt.Errorf("quórum de escritura no alcanzado: %d de %d réplicas verificadas", confirmed, need)
+	}
+	fmt.Printf("🗳️ %s verificado en %d réplica(s)\n", filepath.Base(filePath), confirmed)
+	return results, nil
+}
+
+// QuorumStat lee la versión de path en Quorum.Read dueños vivos y retorna
+// la más nueva. Las réplicas consultadas que tenían otra versión se
+// reparan antes de retornar.
+func (p *Peer) QuorumStat(path string) (FileVersion, error) {
+	var replicas []PeerInfo
+	for _, o := range p.Owners(path) {
+		if o.NodeID == p.NodeID || p.IsAlive(o) {
+			replicas = append(replicas, o)
+		}
+	}
+	need, err := p.quorumSize(config.Current.Quorum.Read, "lectura")
+	if err != nil {
+		return FileVersion{}, err
+	}
+
+	var versions []FileVersion
+	for _, info := range replicas {
+		if len(versions) == need {
+			break
+		}
+		var v FileVersion
+		var err error
+		if info.NodeID == p.NodeID {
+			v = localVersion(path)
+		} else {
+			v, err = p.statAt(net.JoinHostPort(info.IP, info.Port), path)
+		}
+		if err != nil {
+			fmt.Printf("⚠️ Nodo %d no respondió STAT de %s: %v\n", info.ID, path, err)
+			continue
+		}
+		v.Peer = info
+		versions = append(versions, v)
+	}
+	if len(versions) < need {
+		return FileVersion{}, fmt.Errorf("quórum de lectura no alcanzado: %d de %d réplicas", len(versions), need)
+	}
+
+	latest := versions[0]
+	for _, v := range versions[1:] {
+		if v.newerThan(latest) {
+			latest = v
+		}
+	}
+	for _, v := range versions {
+		if v.sameAs(latest) {
+			continue
+		}
+		if err := p.readRepair(path, v, latest); err != nil {
+			fmt.Printf("⚠️ No se pudo reparar %s en nodo %d: %v\n", path, v.Peer.ID, err)
+		}
+	}
+	return latest, nil
+}
+
+// readRepair lleva la réplica stale a la versión latest
+func (p *Peer) readRepair(path string, stale, latest FileVersion) error {
+	if latest.IsDir || stale.IsDir {
+		return fmt.Errorf("las carpetas no se reparan al leer")
+	}
+	if stale.Peer.NodeID == p.NodeID {
+		return p.repairLocal(path, latest)
+	}
+
+	addr := net.JoinHostPort(stale.Peer.IP, stale.Peer.Port)
+	if !latest.Exists {
+		if latest.Op == nil || latest.Op.Type != "DELETE" {
+			return fmt.Errorf("borrado sin operación registrada")
+		}
+		err := p.sendDelete(*latest.Op, addr)
+		if err == nil {
+			fmt.Printf("🩹 %s: DELETE reenviado al nodo %d\n", path, stale.Peer.ID)
+		}
+		return err
+	}
+	// Se envía la copia local, que tiene que ser ya la más nueva
+	if !localVersion(path).sameAs(latest) {
+		return fmt.Errorf("este nodo no tiene la versión más nueva")
+	}
+	err := p.SendFile(path, addr)
+	if err == nil {
+		fmt.Printf("🩹 %s: versión más nueva enviada al nodo %d\n", path, stale.Peer.ID)
+	}
+	return err
+}
+
+// repairLocal deja en disco la versión latest de path: aplica su operación
+// como si llegara por SYNC y, si con eso no alcanza (por ejemplo porque ya
+// estaba en el log), escribe o borra directamente
+func (p *Peer) repairLocal(path string, latest FileVersion) error {
+	if latest.Exists {
+		addr := net.JoinHostPort(latest.Peer.IP, latest.Peer.Port)
+		if err := p.fetchContent(addr, latest.Hash); err != nil {
+			return err
+		}
+	}
+	if latest.Op != nil {
+		fs.SyncWithLogs([]log.Operation{*latest.Op})
+	}
+	if localVersion(path).sameAs(latest) {
+		fmt.Printf("🩹 %s: copia local actualizada\n", path)
+		return nil
+	}
+
+	fs.MarkIncoming(path)
+	if !latest.Exists {
+		return fs.DeletePath(path)
+	}
+	if err := store.Assemble(latest.Hash, path); err != nil {
+		return err
+	}
+	fmt.Printf("🩹 %s: copia local actualizada\n", path)
+	return nil
+}
+
+// newerThan indica si v es una versión más nueva que other. Si ambas
+// réplicas conocen una operación para la ruta decide el orden de las
+// operaciones; si no, la fecha de modificación y luego el hash.
+func (v FileVersion) newerThan(other FileVersion) bool {
+	if v.Op != nil && other.Op != nil && v.Op.ID() != other.Op.ID() {
+		return other.Op.Before(*v.Op)
+	}
+	if v.Exists != other.Exists {
+		return v.Exists
+	}
+	if !v.Mod.Equal(other.Mod) {
+		return v.Mod.After(other.Mod)
+	}
+	return v.Hash > other.Hash
+}
+
+// sameAs indica si v y other tienen el mismo contenido
+func (v FileVersion) sameAs(other FileVersion) bool {
+	return v.Exists == other.Exists && v.Hash == other.Hash
+}
+
+// statAt pide a addr su versión de path
+func (p *Peer) statAt(addr, path string) (FileVersion, error) {
+	resp, err := p.request(addr, message.Message{Type: "STAT", Origin: p.ID, Path: filepath.Base(path)})
+	if err != nil {
+		return FileVersion{}, err
+	}
+	if resp.Type != "STAT" {
+		return FileVersion{}, fmt.Errorf("respuesta inesperada a STAT: %s", resp.Type)
+	}
+	var v FileVersion
+	if resp.FileTree != nil {
+		v.Exists = true
+		v.IsDir = resp.FileTree.IsDir
+		v.Hash = resp.FileTree.Hash
+		v.Size = resp.FileTree.Size
+		v.Mod = resp.FileTree.ModTime
+	}
+	if len(resp.Data) > 0 {
+		var op log.Operation
+		if err := json.Unmarshal(resp.Data, &op); err != nil || op.Verify() != nil {
+			return FileVersion{}, fmt.Errorf("operación de STAT sin firma válida")
+		}
+		v.Op = &op
+	}
+	return v, nil
+}
+
+// localVersion es la versión de path en este nodo
+func localVersion(path string) FileVersion {
+	path = filepath.Join("shared", filepath.Base(path))
+	v := FileVersion{}
+	if node, err := fs.BuildFileTree(path); err == nil {
+		v.Exists, v.IsDir, v.Hash, v.Size, v.Mod = true, node.IsDir, node.Hash, node.Size, node.ModTime
+	}
+	if op, ok := fs.Current(path); ok {
+		v.Op = &op
+	}
+	return v
+}
+
+// handleStat responde con la versión local del archivo pedido
+func (p *Peer) handleStat(conn net.Conn, msg message.Message) {
+	v := localVersion(msg.Path)
+	resp := message.Message{Type: "STAT", Origin: p.ID, Path: msg.Path}
+	if v.Exists {
+		resp.FileTree = &fs.FileNode{
+			Name:    filepath.Base(msg.Path),
+			IsDir:   v.IsDir,
+			ModTime: v.Mod,
+			Size:    v.Size,
+			Hash:    v.Hash,
+		}
+	}
+	if v.Op != nil {
+		resp.Data, _ = json.Marshal(v.Op)
+	}
+	message.WriteMessage(conn, resp)
+}
+
+// quorumSize valida el quórum configurado contra el número de réplicas
+// por archivo: el factor de replicación o, con factor 0, todos los nodos
+func (p *Peer) quorumSize(want int, kind string) (int, error) {
+	replicas := config.Current.Replication.Factor
+	if replicas == 0 {
+		replicas = len(p.RingMembers())
+	}
+	if want > replicas {
+		return 0, fmt.Errorf("quórum de %s (%d) mayor que las réplicas disponibles (%d)", kind, want, replicas)
+	}
+	return want, nil
+}
@@ -0,0 +1,260 @@
+package peer
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"p2pfs/internal/config"
+	"p2pfs/internal/fs"
+	"p2pfs/internal/log"
+)
+
+// Re-replicación: cuando la membresía da a un nodo por caído, sus archivos
+// quedan con menos réplicas de las que pide el factor. El bucle de
+// reparación pregunta a los dueños vivos qué archivos tienen (un MERKLE de
+// la raíz de shared/) y copia cada archivo que le falte a un dueño desde
+// una réplica sobreviviente: la del primer dueño que lo tiene, en orden de
+// anillo, para que un solo nodo repare cada archivo. También corre cada
+// RepairInterval por si una reparación anterior quedó a medias.
+//
+// Un dueño que tiene otra versión del archivo solo se repara si la local es
+// estrictamente más nueva según la historia de operaciones (ver
+// localWins); nunca se reenvía un archivo con lápida.
+//
+// Las reparaciones van de a una y sus bytes pasan por un token bucket de
+// RepairBytesPerSecond, para no quitarle red a las transferencias de los
+// usuarios. Cada una queda en el oplog como REPAIR.
+
+const (
+	// RepairInterval es cada cuánto se revisan las réplicas sin caídas de por medio
+	RepairInterval = 5 * time.Minute
+	// RepairBytesPerSecond limita el tráfico de reparación
+	RepairBytesPerSecond = 2 << 20
+)
+
+// repairLimit es el token bucket que comparten todos los envíos de reparación
+var repairLimit = newTokenBucket(RepairBytesPerSecond)
+
+// repairSignal despierta al bucle de reparación
+func (p *Peer) repairSignal() chan struct{} {
+	p.repairOnce.Do(func() { p.repairCh = make(chan struct{}, 1) })
+	return p.repairCh
+}
+
+// requestRepair pide una pasada de reparación sin bloquear
+func (p *Peer) requestRepair() {
+	select {
+	case p.repairSignal() <- struct{}{}:
+	default: // Ya hay una pedida
+	}
+}
+
+// StartRepair corre el bucle de reparación; no retorna
+func (p *Peer) StartRepair() {
+	ticker := time.NewTicker(RepairInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.repairSignal():
+			// Dejar que la membresía y el reparto se asienten
+			time.Sleep(RebalanceDelay)
+		}
+		if config.Current.Replication.Factor > 0 {
+			p.repairReplicas()
+		}
+	}
+}
+
+// repairReplicas hace una pasada de reparación y retorna cuántas réplicas restauró
+func (p *Peer) repairReplicas() int {
+	entries, err := os.ReadDir("shared")
+	if err != nil {
+		return 0
+	}
+
+	// Qué archivos (nombre → hash) tiene cada miembro vivo del anillo
+	held := make(map[string]map[string]string)
+	for _, m := range p.RingMembers() {
+		if m.NodeID == p.NodeID {
+			continue
+		}
+		tree, err := p.requestSubtree(net.JoinHostPort(m.IP, m.Port), ".")
+		if err != nil {
+			continue // Sin respuesta: no se puede saber qué le falta
+		}
+		files := make(map[string]string)
+		for _, c := range tree.Children {
+			if !c.IsDir {
+				files[c.Name] = c.Hash
+			}
+		}
+		held[m.NodeID] = files
+	}
+
+	local := make(map[string]string)
+	if tree, err := fs.Subtree("shared", "."); err == nil {
+		for _, c := range tree.Children {
+			if !c.IsDir {
+				local[c.Name] = c.Hash
+			}
+		}
+	}
+
+	repaired := 0
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		name := e.Name()
+		path := filepath.Join("shared", name)
+		if _, dead := fs.TombstoneFor(path); dead {
+			continue
+		}
+		hash := local[name]
+		owners := p.Owners(path)
+
+		has := func(o PeerInfo) bool {
+			if o.NodeID == p.NodeID {
+				return hash != ""
+			}
+			files, ok := held[o.NodeID]
+			return ok && files[name] == hash
+		}
+
+		// Un nodo que no es dueño libera su copia cuando todos los dueños
+		// ya tienen esta versión
+		if !containsNode(owners, p.NodeID) && len(owners) > 0 {
+			p.handOff(path, has)
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+		}
+
+		// Repara el primer dueño que tiene el archivo; si ningún dueño lo
+		// tiene, cualquier nodo que conserve una copia
+		var repairer string
+		for _, o := range owners {
+			if has(o) {
+				repairer = o.NodeID
+				break
+			}
+		}
+		if repairer != "" && repairer != p.NodeID {
+			continue
+		}
+
+		for _, o := range owners {
+			if o.NodeID == p.NodeID || has(o) {
+				continue
+			}
+			if _, known := held[o.NodeID]; !known {
+				continue
+			}
+			addr := net.JoinHostPort(o.IP, o.Port)
+			if !p.localWins(addr, name) {
+				continue // El dueño tiene una versión igual o más nueva
+			}
+			if err := p.sendRepair(path, addr); err != nil {
+				fmt.Printf("⚠️ No se pudo reparar %s en nodo %d: %v\n", name, o.ID, err)
+				continue
+			}
+			repaired++
+			fmt.Printf("🩹 Réplica de %s restaurada en nodo %d\n", name, o.ID)
+			log.AppendToLocalLog(log.Operation{
+				Type:     "REPAIR",
+				Path:     path,
+				FileName: name,
+				Hash:     hash,
+				Size:     fileSize(path),
+				Time:     time.Now().Unix(),
+				Message:  fmt.Sprintf("Réplica restaurada en nodo %d (%s)", o.ID, o.NodeID),
+			})
+		}
+	}
+	return repaired
+}
+
+// sendRepair envía path a addr como SendFile, pero con los bytes limitados
+// por repairLimit
+func (p *Peer) sendRepair(path, addr string) error {
+	out, err := p.prepareFile(path)
+	if err != nil {
+		return err
+	}
+	defer out.cleanup()
+	out.limit = repairLimit
+	return p.sendPrepared(out, addr)
+}
+
+// tokenBucket reparte rate bytes por segundo, con ráfagas de hasta un
+// segundo de tráfico
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int) *tokenBucket {
+	return &tokenBucket{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+// take espera hasta que haya n bytes disponibles y los consume. n no puede
+// superar la ráfaga (rate).
+func (b *tokenBucket) take(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens < 0 {
+		// La deuda se paga esperando; quien venga después espera su parte
+		wait := time.Duration(-b.tokens / b.rate * float64(time.Second))
+		time.Sleep(wait)
+		b.tokens = 0
+		b.last = time.Now()
+	}
+}
+
+// limitedConn limita las escrituras de una conexión con un tokenBucket
+type limitedConn struct {
+	io.ReadWriter
+	bucket *tokenBucket
+}
+
+func (c limitedConn) Write(data []byte) (int, error) {
+	written := 0
+	burst := int(c.bucket.rate)
+	for len(data) > 0 {
+		n := len(data)
+		if n > burst {
+			n = burst
+		}
+		c.bucket.take(n)
+		m, err := c.ReadWriter.Write(data[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		data = data[n:]
+	}
+	return written, nil
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
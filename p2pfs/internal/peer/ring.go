@@ -0,0 +1,244 @@
+package peer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"p2pfs/internal/config"
+	"p2pfs/internal/fs"
+	"p2pfs/internal/log"
+)
+
+// Reparto de archivos con hashing consistente: cada nodo ocupa
+// config.Current.Replication.VirtualNodes puntos de un anillo, derivados
+// de su NodeID, y un archivo se guarda en los Factor primeros nodos
+// distintos que siguen al hash de su nombre. Cuando entra o sale un nodo
+// solo cambian de dueño los archivos de los tramos que ese nodo toma o
+// deja, no el resto.
+//
+// Son miembros del anillo este nodo y los peers conocidos que la
+// membresía no da por caídos. Con Factor 0 todos los nodos guardan todo.
+
+// RebalanceDelay agrupa cambios de membresía seguidos antes de repartir
+const RebalanceDelay = 2 * time.Second
+
+// ringPoint es un punto del anillo
+type ringPoint struct {
+	hash uint64
+	node PeerInfo
+}
+
+// hashRing es el anillo de un conjunto de miembros
+type hashRing struct {
+	points []ringPoint
+	size   int // Nodos distintos
+}
+
+func newHashRing(members []PeerInfo, vnodes int) *hashRing {
+	r := &hashRing{size: len(members)}
+	for _, m := range members {
+		for i := 0; i < vnodes; i++ {
+			r.points = append(r.points, ringPoint{hash: ringHash(m.NodeID + "#" + strconv.Itoa(i)), node: m})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i].hash < r.points[j].hash })
+	return r
+}
+
+// owners retorna los n nodos responsables de key, en orden de anillo
+func (r *hashRing) owners(key string, n int) []PeerInfo {
+	if n <= 0 || n > r.size {
+		n = r.size
+	}
+	if n == 0 {
+		return nil
+	}
+	h := ringHash(key)
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+
+	var out []PeerInfo
+	seen := make(map[string]bool)
+	for i := 0; len(out) < n; i++ {
+		node := r.points[(start+i)%len(r.points)].node
+		if !seen[node.NodeID] {
+			seen[node.NodeID] = true
+			out = append(out, node)
+		}
+	}
+	return out
+}
+
+func ringHash(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// selfInfo retorna los datos de este nodo como PeerInfo
+func (p *Peer) selfInfo() PeerInfo {
+	return PeerInfo{ID: p.ID, NodeID: p.NodeID, IP: p.IP, Port: p.Port}
+}
+
+// RingMembers retorna los nodos que hoy forman el anillo
+func (p *Peer) RingMembers() []PeerInfo {
+	members := []PeerInfo{p.selfInfo()}
+	seen := map[string]bool{p.NodeID: true}
+	for _, info := range p.Peers() {
+		if info.NodeID == "" || seen[info.NodeID] || p.Membership().State(info) == StateDead {
+			continue
+		}
+		seen[info.NodeID] = true
+		members = append(members, info)
+	}
+	return members
+}
+
+// Owners retorna los nodos que deben guardar path
+func (p *Peer) Owners(path string) []PeerInfo {
+	return ownersIn(p.RingMembers(), path)
+}
+
+// ownersIn calcula los dueños de path en un anillo con members
+func ownersIn(members []PeerInfo, path string) []PeerInfo {
+	cfg := config.Current.Replication
+	return newHashRing(members, cfg.VirtualNodes).owners(filepath.Base(path), cfg.Factor)
+}
+
+// Holds indica si este nodo es uno de los dueños de path
+func (p *Peer) Holds(path string) bool {
+	return containsNode(p.Owners(path), p.NodeID)
+}
+
+func containsNode(list []PeerInfo, nodeID string) bool {
+	for _, info := range list {
+		if info.NodeID == nodeID {
+			return true
+		}
+	}
+	return false
+}
+
+// startRing fija el anillo de partida para el primer reparto
+func (p *Peer) startRing() {
+	members := p.RingMembers()
+	p.ringMu.Lock()
+	p.ringMembers = members
+	p.ringMu.Unlock()
+}
+
+// scheduleRebalance pide un reparto tras RebalanceDelay; los cambios que
+// lleguen mientras tanto se agrupan en el mismo reparto
+func (p *Peer) scheduleRebalance() {
+	p.ringMu.Lock()
+	defer p.ringMu.Unlock()
+	if p.rebalancer != nil {
+		p.rebalancer.Reset(RebalanceDelay)
+		return
+	}
+	p.rebalancer = time.AfterFunc(RebalanceDelay, p.rebalance)
+}
+
+// rebalance compara el anillo actual con el del último reparto y entrega
+// los archivos locales a los nodos que pasaron a ser sus dueños. Entrega
+// el dueño que deja de serlo (y luego borra su copia) o, si ninguno deja
+// de serlo, el primer dueño que sigue.
+func (p *Peer) rebalance() {
+	// RingMembers toma el lock de la membresía, que a su vez llama a
+	// scheduleRebalance: no tener ringMu mientras tanto
+	current := p.RingMembers()
+	p.ringMu.Lock()
+	old := p.ringMembers
+	p.ringMembers = current
+	p.ringMu.Unlock()
+
+	if old == nil || config.Current.Replication.Factor == 0 {
+		return
+	}
+	entries, err := os.ReadDir("shared")
+	if err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		path := filepath.Join("shared", e.Name())
+		before, after := ownersIn(old, path), ownersIn(current, path)
+
+		var gained, kept []PeerInfo
+		for _, o := range after {
+			if containsNode(before, o.NodeID) {
+				kept = append(kept, o)
+			} else {
+				gained = append(gained, o)
+			}
+		}
+		if len(gained) == 0 {
+			continue
+		}
+		leaving := containsNode(before, p.NodeID) && !containsNode(after, p.NodeID)
+		noneLeft := len(kept) == len(before)
+		first := noneLeft && kept[0].NodeID == p.NodeID
+		if !leaving && !first {
+			continue
+		}
+
+		delivered := 0
+		for _, o := range gained {
+			if err := p.SendFile(path, net.JoinHostPort(o.IP, o.Port)); err != nil {
+				fmt.Printf("⚠️ No se pudo entregar %s al nodo %d: %v\n", path, o.ID, err)
+				continue
+			}
+			delivered++
+		}
+		if leaving && delivered == len(gained) {
+			p.dropReplica(path)
+		}
+	}
+}
+
+// dropReplica borra la copia local de path porque ya no le corresponde a
+// este nodo. No es un borrado del clúster: no se envía DELETE.
+func (p *Peer) dropReplica(path string) {
+	fs.MarkIncoming(path)
+	if err := os.Remove(path); err != nil {
+		return
+	}
+	fmt.Printf("📦 %s entregado a sus dueños, copia local liberada\n", path)
+	log.AppendToLocalLog(log.Operation{
+		Type:    "HANDOFF",
+		Path:    path,
+		Time:    time.Now().Unix(),
+		Message: "Réplica entregada a los dueños del tramo",
+	})
+}
+
+// handOff libera la copia local de path si este nodo no es uno de sus
+// dueños y todos los dueños confirmaron tenerla (confirmed). Así el nodo
+// donde se agregó un archivo (GUI o shared/) no lo guarda para siempre.
+// Solo se liberan archivos de shared/, nunca el original de otra carpeta.
+func (p *Peer) handOff(path string, confirmed func(owner PeerInfo) bool) {
+	if config.Current.Replication.Factor == 0 || !inShared(path) {
+		return
+	}
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		return
+	}
+	owners := p.Owners(path)
+	if len(owners) == 0 || containsNode(owners, p.NodeID) {
+		return
+	}
+	for _, o := range owners {
+		if !confirmed(o) {
+			return
+		}
+	}
+	p.dropReplica(path)
+}
@@ -0,0 +1,82 @@
+package peer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"p2pfs/internal/config"
+)
+
+// Estado del nodo que sobrevive a reinicios: su ID asignado (ligado a la
+// identidad Ed25519) y los peers conocidos. Se guarda en el directorio de
+// datos y se recupera al arrancar para no pedir un ID nuevo.
+
+// nodeState es el contenido de data/node.json
+type nodeState struct {
+	ID     int    `json:"id"`
+	NodeID string `json:"node_id"`
+	IP     string `json:"ip"`
+	Port   string `json:"port"`
+}
+
+var stateMu sync.Mutex
+
+func nodeStateFile() string { return config.Path("node.json") }
+func peersFile() string     { return config.Path("peers.json") }
+
+// SaveState guarda el ID y la lista de peers del nodo
+func (p *Peer) SaveState() {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	if err := os.MkdirAll(config.DataDir, 0755); err != nil {
+		fmt.Println("⚠️ No se pudo guardar el estado del nodo:", err)
+		return
+	}
+	data, _ := json.MarshalIndent(nodeState{ID: p.ID, NodeID: p.NodeID, IP: p.IP, Port: p.Port}, "", "  ")
+	if err := os.WriteFile(nodeStateFile(), data, 0644); err != nil {
+		fmt.Println("⚠️ No se pudo guardar el estado del nodo:", err)
+	}
+	if err := SavePeersToFile(p.Peers(), peersFile()); err != nil {
+		fmt.Println("⚠️ No se pudo guardar la lista de peers:", err)
+	}
+}
+
+// RestoreState recupera el ID y los peers guardados. Retorna true si el
+// nodo recuperó su ID; un estado de otra identidad se ignora.
+func (p *Peer) RestoreState() bool {
+	stateMu.Lock()
+	peers, _ := LoadPeersFromFile(peersFile())
+	data, err := os.ReadFile(nodeStateFile())
+	stateMu.Unlock()
+
+	for _, info := range peers {
+		if info.NodeID != p.NodeID {
+			p.AddPeer(info)
+		}
+	}
+	if err != nil {
+		return false
+	}
+	var st nodeState
+	if err := json.Unmarshal(data, &st); err != nil {
+		fmt.Println("⚠️ Estado del nodo ilegible:", err)
+		return false
+	}
+	if st.ID == 0 || st.NodeID != p.NodeID {
+		return false
+	}
+
+	idMutex.Lock()
+	defer idMutex.Unlock()
+	for _, info := range p.Peers() {
+		if info.ID != 0 && info.NodeID != "" {
+			recordOwner(info.ID, info.NodeID)
+		}
+	}
+	p.ID = st.ID
+	recordOwner(p.ID, p.NodeID)
+	return true
+}
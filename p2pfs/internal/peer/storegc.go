@@ -0,0 +1,23 @@
+package peer
+
+import (
+	"fmt"
+	"time"
+
+	"p2pfs/internal/fs"
+)
+
+// StoreGCInterval es cada cuánto se borran del almacén las versiones que
+// ya no están vivas (ver fs.CollectStore)
+const StoreGCInterval = 10 * time.Minute
+
+// StartStoreGC corre el GC del almacén de bloques en segundo plano
+func (p *Peer) StartStoreGC() {
+	for {
+		time.Sleep(StoreGCInterval)
+		manifests, chunks := fs.CollectStore()
+		if manifests > 0 || chunks > 0 {
+			fmt.Printf("🧹 Almacén: %d manifiesto(s) y %d bloque(s) sin referencias borrados\n", manifests, chunks)
+		}
+	}
+}
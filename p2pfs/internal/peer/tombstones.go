@@ -0,0 +1,62 @@
+package peer
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"p2pfs/internal/fs"
+	"p2pfs/internal/message"
+)
+
+// TombstoneGCInterval es cada cuánto se piden cursores a los peers para
+// confirmar lápidas y descartar las que ya tienen todos
+const TombstoneGCInterval = time.Minute
+
+// StartTombstoneGC pide periódicamente los cursores de SYNC de los peers
+// vivos y descarta las lápidas que confirmaron todos los miembros conocidos
+func (p *Peer) StartTombstoneGC() {
+	for {
+		time.Sleep(TombstoneGCInterval)
+		p.collectTombstones()
+	}
+}
+
+// collectTombstones hace una pasada de confirmación y descarte de lápidas
+func (p *Peer) collectTombstones() {
+	if len(fs.LoadTombstones()) == 0 {
+		return
+	}
+	for _, info := range p.LivePeers() {
+		addr := net.JoinHostPort(info.IP, info.Port)
+		resp, err := p.request(addr, message.Message{Type: "CURSORS", Origin: p.ID})
+		if err != nil || resp.Type != "CURSORS" {
+			continue
+		}
+		fs.AckTombstones(info.NodeID, resp.Cursors)
+	}
+
+	if n := fs.CollectTombstones(p.knownMembers()); n > 0 {
+		fmt.Printf("🪦 %d lápida(s) confirmada(s) por todos los nodos, descartada(s)\n", n)
+	}
+}
+
+// knownMembers retorna los NodeIDs de todos los peers conocidos, vivos o
+// no, sin contar este nodo
+func (p *Peer) knownMembers() []string {
+	seen := map[string]bool{p.NodeID: true}
+	var ids []string
+	add := func(id string) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	for _, info := range p.Peers() {
+		add(info.NodeID)
+	}
+	for _, m := range p.Membership().Members() {
+		add(m.NodeID)
+	}
+	return ids
+}
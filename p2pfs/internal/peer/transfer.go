@@ -0,0 +1,434 @@
+package peer
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"p2pfs/internal/message"
+	"p2pfs/internal/store"
+	"p2pfs/internal/utils"
+)
+
+// TransferAckTimeout es cuánto se espera a que el receptor verifique el hash
+// (y descomprima, si es un ZIP) después del último bloque
+const TransferAckTimeout = time.Minute
+
+// Transferencias entrantes en curso (por hash), para que dos emisores del
+// mismo archivo no escriban a la vez en el mismo archivo parcial.
+var (
+	activeTransfers = make(map[string]bool)
+	activeMutex     sync.Mutex
+)
+
+// chunkReader obtiene el contenido del bloque i de lo que se está enviando
+type chunkReader func(index int) ([]byte, error)
+
+// fileChunks lee los bloques directamente de un archivo abierto
+func fileChunks(file io.ReaderAt, size int64, chunkSize int) chunkReader {
+	return func(index int) ([]byte, error) {
+		off := int64(index) * int64(chunkSize)
+		n := int64(chunkSize)
+		if off+n > size {
+			n = size - off
+		}
+		buf := make([]byte, n)
+		if _, err := file.ReadAt(buf, off); err != nil && err != io.EOF {
+			return nil, err
+		}
+		return buf, nil
+	}
+}
+
+// storeChunks lee los bloques de un manifiesto desde el almacén local
+func storeChunks(m store.Manifest) chunkReader {
+	return func(index int) ([]byte, error) {
+		return store.GetChunk(m.Chunks[index])
+	}
+}
+
+// transferResult resume cómo se completó un envío
+type transferResult struct {
+	Offset  int64 // Bytes que el receptor ya tenía (RESUME)
+	Sent    int   // Bloques enviados por la red
+	Delta   bool  // El receptor pidió transferencia delta
+	Literal int64 // Bytes literales enviados en modo delta
+}
+
+// transferHeader construye la cabecera TRANSFER de un manifiesto
+func (p *Peer) transferHeader(m store.Manifest, filename string) message.Message {
+	return message.Message{
+		Type:      "TRANSFER",
+		Origin:    p.ID,
+		Path:      filename,
+		Hash:      m.Hash,
+		Size:      m.Size,
+		ChunkSize: m.ChunkSize,
+		Chunks:    m.Chunks,
+		Time:      time.Now().Unix(),
+	}
+}
+
+// streamFile envía la cabecera TRANSFER (con la lista de bloques) y espera
+// la respuesta del receptor: con RESUME manda solo los bloques que este
+// pide; con SIGNATURE (ya tiene otra versión del archivo) manda un delta.
+// Nunca hay más de un bloque en memoria.
+func streamFile(rw io.ReadWriter, header message.Message, read chunkReader) (transferResult, error) {
+	var res transferResult
+	if err := message.WriteMessage(rw, header); err != nil {
+		return res, err
+	}
+
+	resp, err := message.ReadMessage(rw)
+	if err != nil {
+		return res, fmt.Errorf("sin respuesta RESUME: %w", err)
+	}
+	switch resp.Type {
+	case "RESUME":
+	case "SIGNATURE":
+		return sendDelta(rw, header, resp, read)
+	default:
+		return res, fmt.Errorf("se esperaba RESUME o SIGNATURE, llegó %s", resp.Type)
+	}
+
+	offset := resp.Offset
+	if offset < 0 {
+		return res, fmt.Errorf("el receptor rechazó la transferencia")
+	}
+	if offset > header.Size || (offset%int64(header.ChunkSize) != 0 && offset != header.Size) {
+		return res, fmt.Errorf("offset RESUME inválido: %d", offset)
+	}
+	res.Offset = offset
+
+	first := int(offset / int64(header.ChunkSize))
+	for _, index := range resp.Need {
+		if index < first || index >= len(header.Chunks) {
+			return res, fmt.Errorf("índice de bloque inválido en RESUME: %d", index)
+		}
+	}
+
+	for _, index := range resp.Need {
+		data, err := read(index)
+		if err != nil {
+			return res, err
+		}
+		if err := message.WriteChunk(rw, message.NewChunk(uint32(index), data)); err != nil {
+			return res, err
+		}
+		res.Sent++
+	}
+	return res, nil
+}
+
+// receiveFile negocia el RESUME de una transferencia entrante y recibe los
+// bloques que faltan en el archivo parcial. Los bloques que ya están en el
+// almacén (de este u otros archivos) no se piden por la red. Retorna el
+// SHA-256 del contenido completo.
+func (p *Peer) receiveFile(conn net.Conn, header message.Message, filename string) (string, error) {
+	file, offset, h, err := prepareResume(header, filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	first := int(offset / int64(header.ChunkSize))
+	need := []int{}
+	for i := first; i < len(header.Chunks); i++ {
+		if !store.HasChunk(header.Chunks[i]) {
+			need = append(need, i)
+		}
+	}
+	if offset > 0 {
+		fmt.Printf("⏯️ Reanudando %s desde el byte %d de %d\n", filename, offset, header.Size)
+	}
+	if reused := len(header.Chunks) - first - len(need); reused > 0 {
+		fmt.Printf("♻️ %d bloque(s) de %s ya estaban en el almacén\n", reused, filename)
+	}
+
+	// Indicar al emisor desde dónde continuar y qué bloques faltan
+	if err := message.WriteMessage(conn, message.Message{
+		Type:   "RESUME",
+		Origin: p.ID,
+		Hash:   header.Hash,
+		Offset: offset,
+		Need:   need,
+	}); err != nil {
+		return "", err
+	}
+
+	actualHash, err := receiveChunks(conn, file, h, header, offset, need, filename)
+	if err != nil {
+		return "", err
+	}
+
+	if actualHash == header.Hash {
+		store.SaveManifest(store.Manifest{
+			Hash:      header.Hash,
+			Name:      filename,
+			Size:      header.Size,
+			ChunkSize: header.ChunkSize,
+			Chunks:    header.Chunks,
+		})
+	}
+	return actualHash, nil
+}
+
+// prepareResume abre el archivo parcial de una transferencia entrante y
+// decide desde qué offset reanudar. El hasher devuelto ya contiene los
+// bytes verificados previamente.
+func prepareResume(header message.Message, filename string) (*os.File, int64, hash.Hash, error) {
+	h := sha256.New()
+	partPath := utils.PartialPath(header.Hash)
+
+	var offset int64
+	state, ok := utils.GetTransferState(header.Hash)
+	if ok && state.Size == header.Size && state.ChunkSize == header.ChunkSize {
+		offset = state.Received
+	}
+
+	if err := os.MkdirAll(filepath.Dir(partPath), 0755); err != nil {
+		return nil, 0, nil, err
+	}
+	file, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	// Rehashear el prefijo ya recibido; si el parcial es más corto de lo
+	// que dice el diario, empezar de cero
+	if offset > 0 {
+		n, err := io.CopyN(h, file, offset)
+		if err != nil || n != offset {
+			h.Reset()
+			offset = 0
+		}
+	}
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return nil, 0, nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, 0, nil, err
+	}
+
+	utils.UpdateTransferState(utils.TransferState{
+		Hash:      header.Hash,
+		FileName:  filename,
+		Size:      header.Size,
+		ChunkSize: header.ChunkSize,
+		Received:  offset,
+		Updated:   time.Now().Unix(),
+	})
+	return file, offset, h, nil
+}
+
+// receiveChunks recorre los bloques desde el offset: los de need llegan
+// por la red y se guardan en el almacén, el resto se copia del almacén.
+// El progreso se guarda en el diario tras cada bloque para poder reanudar
+// si la conexión se corta. Retorna el SHA-256 del contenido completo.
+func receiveChunks(r io.Reader, dst io.Writer, h hash.Hash, header message.Message, offset int64, need []int, filename string) (string, error) {
+	w := io.MultiWriter(dst, h)
+
+	received := offset
+	pending := need
+	for index := int(offset / int64(header.ChunkSize)); index < len(header.Chunks); index++ {
+		var data []byte
+		if len(pending) > 0 && pending[0] == index {
+			c, err := message.ReadChunk(r)
+			if err != nil {
+				return "", err
+			}
+			if int(c.Index) != index {
+				return "", fmt.Errorf("bloque fuera de orden: esperado %d, llegó %d", index, c.Index)
+			}
+			if fmt.Sprintf("%x", c.Sum) != header.Chunks[index] {
+				return "", fmt.Errorf("bloque %d no coincide con la lista de la cabecera", index)
+			}
+			if _, err := store.PutChunk(c.Data); err != nil {
+				return "", err
+			}
+			data = c.Data
+			pending = pending[1:]
+		} else {
+			var err error
+			if data, err = store.GetChunk(header.Chunks[index]); err != nil {
+				return "", err
+			}
+		}
+
+		if _, err := w.Write(data); err != nil {
+			return "", err
+		}
+		received += int64(len(data))
+
+		utils.UpdateTransferState(utils.TransferState{
+			Hash:      header.Hash,
+			FileName:  filename,
+			Size:      header.Size,
+			ChunkSize: header.ChunkSize,
+			Received:  received,
+			Updated:   time.Now().Unix(),
+		})
+	}
+
+	if received != header.Size {
+		return "", fmt.Errorf("tamaño recibido %d distinto del anunciado %d", received, header.Size)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// validTransferHeader comprueba los campos de una cabecera TRANSFER
+func validTransferHeader(header message.Message) error {
+	if !utils.ValidHash(header.Hash) {
+		return fmt.Errorf("hash inválido en cabecera TRANSFER: %q", header.Hash)
+	}
+	if header.Size < 0 || header.ChunkSize <= 0 || header.ChunkSize > message.MaxPayloadSize {
+		return fmt.Errorf("cabecera TRANSFER inválida (size=%d, chunk=%d)", header.Size, header.ChunkSize)
+	}
+	if len(header.Chunks) != store.NumChunks(header.Size, header.ChunkSize) {
+		return fmt.Errorf("la cabecera TRANSFER anuncia %d bloques, se esperaban %d",
+			len(header.Chunks), store.NumChunks(header.Size, header.ChunkSize))
+	}
+	for _, c := range header.Chunks {
+		if !utils.ValidHash(c) {
+			return fmt.Errorf("hash de bloque inválido en cabecera TRANSFER: %q", c)
+		}
+	}
+	return nil
+}
+
+// handleFetch sirve desde el almacén el contenido pedido por hash. Tras la
+// cabecera TRANSFER el solicitante responde con RESUME como cualquier
+// receptor, así que solo viajan los bloques que no tiene.
+func (p *Peer) handleFetch(conn net.Conn, msg message.Message) {
+	// Sin el contenido se responde FETCH con el motivo, para que quien
+	// pidió no quede esperando
+	unavailable := func(reason string) {
+		fmt.Printf("⚠️ Contenido %s no disponible: %s\n", msg.Hash, reason)
+		message.WriteMessage(conn, message.Message{Type: "FETCH", Origin: p.ID, Hash: msg.Hash, Reason: reason})
+	}
+	m, err := store.LoadManifest(msg.Hash)
+	if err != nil {
+		unavailable("no está en el almacén")
+		return
+	}
+	read := storeChunks(m)
+	if len(store.Missing(m)) > 0 {
+		// Sin bloques: servir desde la copia completa en shared/
+		path, ok := store.CopyOf(m)
+		if !ok {
+			unavailable("no está en el almacén")
+			return
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			unavailable(err.Error())
+			return
+		}
+		defer file.Close()
+		read = fileChunks(file, m.Size, m.ChunkSize)
+	}
+
+	res, err := streamFile(conn, p.transferHeader(m, m.Name), read)
+	if err != nil {
+		fmt.Printf("❌ Error al servir %s: %v\n", m.Name, err)
+		return
+	}
+	fmt.Printf("📤 Servido %s (%d de %d bloques)\n", m.Name, res.Sent, len(m.Chunks))
+}
+
+// fetchContent pide a addr el contenido con el hash dado y lo deja en el
+// almacén local
+func (p *Peer) fetchContent(addr, hash string) error {
+	if store.Complete(hash) {
+		return nil
+	}
+
+	conn, err := p.dial(addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := message.WriteMessage(conn, message.Message{Type: "FETCH", Origin: p.ID, Hash: hash}); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(RequestTimeout))
+	header, err := message.ReadMessage(conn)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return fmt.Errorf("contenido %s no disponible en %s: %w", hash, addr, err)
+	}
+	if header.Type == "FETCH" {
+		return fmt.Errorf("contenido %s no disponible en %s: %s", hash, addr, header.Reason)
+	}
+	if header.Type != "TRANSFER" || header.Hash != hash {
+		return fmt.Errorf("respuesta inesperada a FETCH: %s %s", header.Type, header.Hash)
+	}
+	if err := validTransferHeader(header); err != nil {
+		return err
+	}
+	if !claimTransfer(hash) {
+		return fmt.Errorf("transferencia de %s ya en curso", hash)
+	}
+	defer releaseTransfer(hash)
+
+	actualHash, err := p.receiveFile(conn, header, filepath.Base(header.Path))
+	if err != nil {
+		return err
+	}
+	// El contenido ya está en el almacén; el parcial no hace falta
+	utils.RemoveTransferState(hash)
+	if actualHash != hash {
+		return fmt.Errorf("hash inválido al obtener %s", hash)
+	}
+	return nil
+}
+
+// awaitAck espera el TRANSFER_ACK del receptor tras enviar los bloques. Un
+// TRANSFER_NACK, o no recibir respuesta, cuenta como envío fallido.
+func awaitAck(conn net.Conn, header message.Message) error {
+	conn.SetReadDeadline(time.Now().Add(TransferAckTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	resp, err := message.ReadMessage(conn)
+	if err != nil {
+		return fmt.Errorf("sin confirmación del receptor: %w", err)
+	}
+	if resp.Hash != header.Hash {
+		return fmt.Errorf("confirmación de otro contenido: %s", resp.Hash)
+	}
+	switch resp.Type {
+	case "TRANSFER_ACK":
+		return nil
+	case "TRANSFER_NACK":
+		return fmt.Errorf("el receptor descartó la copia: %s", resp.Reason)
+	default:
+		return fmt.Errorf("se esperaba TRANSFER_ACK, llegó %s", resp.Type)
+	}
+}
+
+// claimTransfer marca una transferencia entrante como activa
+func claimTransfer(hash string) bool {
+	activeMutex.Lock()
+	defer activeMutex.Unlock()
+	if activeTransfers[hash] {
+		return false
+	}
+	activeTransfers[hash] = true
+	return true
+}
+
+// releaseTransfer libera una transferencia entrante
+func releaseTransfer(hash string) {
+	activeMutex.Lock()
+	defer activeMutex.Unlock()
+	delete(activeTransfers, hash)
+}
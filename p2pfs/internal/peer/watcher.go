@@ -0,0 +1,111 @@
+package peer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"p2pfs/internal/fs"
+)
+
+// Watcher de shared/: los archivos que el usuario crea, modifica, renombra
+// o borra fuera de la GUI se replican solos. Los eventos de cada ruta se
+// agrupan durante WatchDebounce y al final se mira el estado del archivo:
+// si existe se registra un TRANSFER y se envía a los peers, y si no, se
+// borra en el clúster (DeleteFile registra el DELETE). Los eventos que provocan
+// las escrituras de operaciones recibidas se descartan (fs.IsEcho).
+//
+// Solo se vigilan los archivos directamente en shared/, igual que lo que
+// admiten las transferencias.
+
+// WatchDebounce es cuánto se espera sin eventos antes de procesar una ruta
+const WatchDebounce = 500 * time.Millisecond
+
+// StartWatcher vigila dir y replica los cambios locales
+func (p *Peer) StartWatcher(dir string) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fmt.Printf("⚠️ No se pudo crear %s: %v\n", dir, err)
+		return
+	}
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		fmt.Printf("⚠️ No se pudo iniciar el watcher: %v\n", err)
+		return
+	}
+	defer w.Close()
+	if err := w.Add(dir); err != nil {
+		fmt.Printf("⚠️ No se pudo vigilar %s: %v\n", dir, err)
+		return
+	}
+	fmt.Printf("👀 Vigilando cambios en %s\n", dir)
+
+	var (
+		mu      sync.Mutex
+		pending = make(map[string]*time.Timer)
+	)
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if ignoredName(filepath.Base(ev.Name)) {
+				continue
+			}
+			path := filepath.Join(dir, filepath.Base(ev.Name))
+			mu.Lock()
+			if t, ok := pending[path]; ok {
+				t.Reset(WatchDebounce)
+			} else {
+				pending[path] = time.AfterFunc(WatchDebounce, func() {
+					mu.Lock()
+					delete(pending, path)
+					mu.Unlock()
+					p.replicateChange(path)
+				})
+			}
+			mu.Unlock()
+
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			fmt.Printf("⚠️ Error del watcher: %v\n", err)
+		}
+	}
+}
+
+// replicateChange propaga el estado actual de path si es un cambio local
+func (p *Peer) replicateChange(path string) {
+	if fs.IsEcho(path) {
+		return
+	}
+
+	info, err := os.Stat(path)
+	switch {
+	case os.IsNotExist(err):
+		fmt.Printf("👀 %s borrado localmente, propagando\n", path)
+		if _, err := p.DeleteFile(path); err != nil {
+			fmt.Printf("⚠️ No se pudo propagar el borrado de %s: %v\n", path, err)
+		}
+	case err != nil || info.IsDir():
+		// Carpetas: las transferencias no admiten subcarpetas
+	default:
+		fmt.Printf("👀 %s cambió localmente, replicando\n", path)
+		// Cada envío registra su TRANSFER; los peers caídos quedan en cola
+		if _, err := p.BroadcastFile(path); err != nil {
+			fmt.Printf("⚠️ No se pudo replicar %s: %v\n", path, err)
+		}
+	}
+}
+
+// ignoredName descarta archivos temporales de editores
+func ignoredName(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") ||
+		strings.HasSuffix(name, ".swp") || strings.HasSuffix(name, ".tmp")
+}
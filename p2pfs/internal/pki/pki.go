@@ -0,0 +1,216 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Infraestructura de certificados del clúster: una CA propia firma un
+// certificado por nodo y las conexiones TCP entre nodos usan TLS mutuo.
+// Solo se aceptan pares cuyo certificado esté firmado por la CA; la IP no
+// se comprueba porque las máquinas del laboratorio cambian de dirección.
+
+const (
+	CACertFile = "ca.crt"
+	CAKeyFile  = "ca.key"
+
+	caValidity   = 10 * 365 * 24 * time.Hour
+	nodeValidity = 2 * 365 * 24 * time.Hour
+)
+
+var ErrUnknownCertificate = errors.New("certificado no firmado por la CA del clúster")
+
+// InitCA crea la CA del clúster en dir (ca.crt y ca.key)
+func InitCA(dir, name string) error {
+	if _, err := os.Stat(filepath.Join(dir, CAKeyFile)); err == nil {
+		return fmt.Errorf("ya existe una CA en %s", dir)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: name, Organization: []string{"p2pfs"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	return writeKeyPair(dir, CACertFile, CAKeyFile, der, key)
+}
+
+// IssueCert firma con la CA de caDir un certificado para el nodo name y lo
+// guarda en outDir como <name>.crt / <name>.key
+func IssueCert(caDir, outDir, name string, ips []net.IP) error {
+	caCert, caKey, err := loadCA(caDir)
+	if err != nil {
+		return err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: name, Organization: []string{"p2pfs"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(nodeValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	return writeKeyPair(outDir, name+".crt", name+".key", der, key)
+}
+
+// ServerConfig construye la configuración TLS del listener: exige
+// certificado de cliente firmado por la CA
+func ServerConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	pool, cert, err := loadNode(caFile, certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// ClientConfig construye la configuración TLS para conectar con otros
+// nodos: presenta el certificado propio y verifica el del servidor contra
+// la CA (sin comprobar nombre ni IP)
+func ClientConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	pool, cert, err := loadNode(caFile, certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true, // la verificación real está en VerifyPeerCertificate
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyChain(pool, rawCerts)
+		},
+	}, nil
+}
+
+// verifyChain comprueba que el certificado presentado lo firmó la CA
+func verifyChain(pool *x509.CertPool, rawCerts [][]byte) error {
+	if len(rawCerts) == 0 {
+		return ErrUnknownCertificate
+	}
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		c, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, c)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnknownCertificate, err)
+	}
+	return nil
+}
+
+func loadNode(caFile, certFile, keyFile string) (*x509.CertPool, tls.Certificate, error) {
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, tls.Certificate{}, fmt.Errorf("no se pudo leer la CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, tls.Certificate{}, fmt.Errorf("CA inválida en %s", caFile)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, tls.Certificate{}, fmt.Errorf("no se pudo cargar el certificado del nodo: %w", err)
+	}
+	return pool, cert, nil
+}
+
+func loadCA(dir string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPEM, err := os.ReadFile(filepath.Join(dir, CACertFile))
+	if err != nil {
+		return nil, nil, fmt.Errorf("no se pudo leer la CA (¿falta 'p2pfs ca init'?): %w", err)
+	}
+	keyPEM, err := os.ReadFile(filepath.Join(dir, CAKeyFile))
+	if err != nil {
+		return nil, nil, fmt.Errorf("no se pudo leer la clave de la CA: %w", err)
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, nil, fmt.Errorf("CA con formato PEM inválido en %s", dir)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func writeKeyPair(dir, certName, keyName string, der []byte, key *ecdsa.PrivateKey) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, certName), certPEM, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, keyName), keyPEM, 0600)
+}
+
+func randomSerial() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return big.NewInt(time.Now().UnixNano())
+	}
+	return serial
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"p2pfs/internal/config"
+)
+
+// GC: cada versión que pasa por el almacén deja su manifiesto y sus
+// bloques. Collect borra los manifiestos que ya no describen una versión
+// viva y los bloques que ningún manifiesto vivo sin copia en disco
+// referencia: una versión completa en shared/ se lee de su copia, así que
+// sus bloques sobran (un bloque compartido con una versión sin copia vive
+// mientras esa lo use).
+//
+// Los archivos modificados hace menos de GCGrace no se tocan: pueden ser
+// de una transferencia en curso cuyo manifiesto todavía no se guardó.
+
+// GCGrace es la antigüedad mínima de un bloque o manifiesto para borrarlo
+const GCGrace = time.Hour
+
+// Collect borra los manifiestos cuyo hash no está en live y los bloques sin
+// referencias de versiones que no tienen copia en disco. Retorna cuántos
+// manifiestos y bloques borró.
+func Collect(live map[string]bool) (int, int) {
+	cutoff := time.Now().Add(-GCGrace)
+	refs := make(map[string]int)
+	removedManifests := 0
+
+	entries, _ := os.ReadDir(config.Path("manifests"))
+	for _, e := range entries {
+		hash := strings.TrimSuffix(e.Name(), ".json")
+		if e.IsDir() || hash == e.Name() {
+			continue
+		}
+		m, err := LoadManifest(hash)
+		if err == nil && (live[hash] || recent(manifestPath(hash), cutoff)) {
+			if _, ok := CopyOf(m); !ok {
+				for _, c := range m.Chunks {
+					refs[c]++
+				}
+			}
+			continue
+		}
+		if os.Remove(manifestPath(hash)) == nil {
+			removedManifests++
+		}
+	}
+
+	removedChunks := 0
+	filepath.WalkDir(config.Path("chunks"), func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || refs[d.Name()] > 0 || recent(path, cutoff) {
+			return nil
+		}
+		if os.Remove(path) == nil {
+			removedChunks++
+		}
+		return nil
+	})
+	return removedManifests, removedChunks
+}
+
+// recent indica si path se modificó después de cutoff
+func recent(path string, cutoff time.Time) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.ModTime().After(cutoff)
+}
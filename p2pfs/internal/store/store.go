@@ -0,0 +1,301 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"p2pfs/internal/config"
+	"p2pfs/internal/utils"
+)
+
+// El almacén guarda el contenido de los archivos de shared/ partido en
+// bloques direccionados por su SHA-256, de modo que un bloque repetido en
+// varios archivos (p. ej. carpetas casi idénticas) se guarda y transmite
+// una sola vez. Cada archivo se describe con un Manifest, indexado por el
+// SHA-256 del archivo completo; shared/ es la vista materializada.
+//
+// Para no guardar cada archivo dos veces, el manifiesto de una versión que
+// está completa en shared/ apunta a esa copia (Copy), y Collect descarta sus
+// bloques: el contenido se lee del archivo mientras no cambien su tamaño ni
+// su fecha. Los bloques solo se conservan para las versiones sin copia en
+// disco (p. ej. la perdedora de un conflicto) y mientras dura una
+// transferencia. Las versiones que dejan de estar vivas se borran del todo
+// (ver gc.go).
+//
+//	data/chunks/ab/abcdef...    bloque
+//	data/manifests/1234....json manifiesto
+
+// Manifest describe un archivo como lista ordenada de bloques.
+type Manifest struct {
+	Hash      string   `json:"hash"`       // SHA-256 del archivo completo
+	Name      string   `json:"name"`       // Último nombre conocido en shared/
+	Size      int64    `json:"size"`       // Tamaño total en bytes
+	ChunkSize int      `json:"chunk_size"` // Tamaño de bloque (el último puede ser menor)
+	Chunks    []string `json:"chunks"`     // SHA-256 de cada bloque, en orden
+	Copy      *Copy    `json:"copy,omitempty"`
+}
+
+// Copy es una copia completa de la versión en disco
+type Copy struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// NumChunks retorna cuántos bloques corresponden a size con bloques de chunkSize
+func NumChunks(size int64, chunkSize int) int {
+	if size <= 0 {
+		return 0
+	}
+	return int((size + int64(chunkSize) - 1) / int64(chunkSize))
+}
+
+func chunkPath(hash string) string {
+	return config.Path("chunks", hash[:2], hash)
+}
+
+func manifestPath(hash string) string {
+	return config.Path("manifests", hash+".json")
+}
+
+// HasChunk indica si el bloque ya está en el almacén
+func HasChunk(hash string) bool {
+	if !utils.ValidHash(hash) {
+		return false
+	}
+	_, err := os.Stat(chunkPath(hash))
+	return err == nil
+}
+
+// PutChunk guarda un bloque y retorna su hash. Si ya existe no se reescribe.
+func PutChunk(data []byte) (string, error) {
+	hash := fmt.Sprintf("%x", sha256.Sum256(data))
+	if HasChunk(hash) {
+		// Renovar la fecha: el GC no borra bloques recién usados
+		now := time.Now()
+		os.Chtimes(chunkPath(hash), now, now)
+		return hash, nil
+	}
+
+	path := chunkPath(hash)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+
+	// Escribir en un temporal propio y renombrar: un bloque nunca queda a
+	// medias, y dos escritores del mismo bloque no se pisan
+	tmp, err := os.CreateTemp(filepath.Dir(path), hash+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		if HasChunk(hash) {
+			return hash, nil // Otro escritor guardó el mismo bloque
+		}
+		return "", err
+	}
+	return hash, nil
+}
+
+// GetChunk lee un bloque y verifica que su contenido coincide con el hash
+func GetChunk(hash string) ([]byte, error) {
+	if !utils.ValidHash(hash) {
+		return nil, fmt.Errorf("hash de bloque inválido: %q", hash)
+	}
+	data, err := os.ReadFile(chunkPath(hash))
+	if err != nil {
+		return nil, err
+	}
+	if fmt.Sprintf("%x", sha256.Sum256(data)) != hash {
+		return nil, fmt.Errorf("bloque %s corrupto en el almacén", hash)
+	}
+	return data, nil
+}
+
+// SaveManifest guarda el manifiesto de un archivo
+func SaveManifest(m Manifest) error {
+	path := manifestPath(m.Hash)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// LoadManifest lee el manifiesto de un archivo por su hash
+func LoadManifest(hash string) (Manifest, error) {
+	var m Manifest
+	if !utils.ValidHash(hash) {
+		return m, fmt.Errorf("hash de archivo inválido: %q", hash)
+	}
+	data, err := os.ReadFile(manifestPath(hash))
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("manifiesto %s inválido: %w", hash, err)
+	}
+	return m, nil
+}
+
+// Complete indica si el almacén tiene el manifiesto y todo su contenido,
+// en bloques o en su copia en disco
+func Complete(hash string) bool {
+	m, err := LoadManifest(hash)
+	if err != nil {
+		return false
+	}
+	if _, ok := CopyOf(m); ok {
+		return true
+	}
+	return len(Missing(m)) == 0
+}
+
+// Materialize registra path como copia completa de la versión hash
+func Materialize(hash, path string) error {
+	m, err := LoadManifest(hash)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() != m.Size {
+		return fmt.Errorf("%s no es una copia de %s", path, hash)
+	}
+	m.Copy = &Copy{Path: path, Size: info.Size(), ModTime: info.ModTime()}
+	return SaveManifest(m)
+}
+
+// CopyOf retorna la copia en disco de m si sigue intacta (mismo tamaño y
+// fecha que cuando se registró)
+func CopyOf(m Manifest) (string, bool) {
+	if m.Copy == nil {
+		return "", false
+	}
+	info, err := os.Stat(m.Copy.Path)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != m.Copy.Size || !info.ModTime().Equal(m.Copy.ModTime) {
+		return "", false
+	}
+	return m.Copy.Path, true
+}
+
+// Missing retorna los índices de bloques del manifiesto que faltan
+func Missing(m Manifest) []int {
+	var missing []int
+	for i, h := range m.Chunks {
+		if !HasChunk(h) {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// Import parte un archivo en bloques, los guarda y registra su manifiesto
+func Import(path string, chunkSize int) (Manifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer file.Close()
+
+	m := Manifest{Name: filepath.Base(path), ChunkSize: chunkSize}
+	h := sha256.New()
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(file, buf)
+		if n > 0 {
+			h.Write(buf[:n])
+			chunkHash, perr := PutChunk(buf[:n])
+			if perr != nil {
+				return Manifest{}, perr
+			}
+			m.Chunks = append(m.Chunks, chunkHash)
+			m.Size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return Manifest{}, err
+		}
+	}
+
+	m.Hash = fmt.Sprintf("%x", h.Sum(nil))
+	return m, SaveManifest(m)
+}
+
+// Assemble reconstruye en dest un archivo a partir de sus bloques o, si ya
+// no están, de su copia en disco, y registra dest como su copia
+func Assemble(hash, dest string) error {
+	m, err := LoadManifest(hash)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	tmp := dest + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	w := io.MultiWriter(out, h)
+	if src, ok := CopyOf(m); ok && len(Missing(m)) > 0 {
+		err = copyFile(w, src)
+	} else {
+		for _, chunkHash := range m.Chunks {
+			var data []byte
+			if data, err = GetChunk(chunkHash); err == nil {
+				_, err = w.Write(data)
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+	out.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if fmt.Sprintf("%x", h.Sum(nil)) != m.Hash {
+		os.Remove(tmp)
+		return fmt.Errorf("el archivo reconstruido no coincide con %s", m.Hash)
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		return err
+	}
+	return Materialize(hash, dest)
+}
+
+func copyFile(w io.Writer, path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	_, err = io.Copy(w, in)
+	return err
+}
@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var journalFile = "log/transfers.json"
+var partialDir = "log/partial"
+var journalMu sync.Mutex
+
+// TransferState registra cuántos bytes verificados de un archivo entrante
+// ya están en disco, para poder reanudar la transferencia tras un corte.
+type TransferState struct {
+	Hash      string `json:"hash"`       // SHA-256 anunciado en la cabecera TRANSFER
+	FileName  string `json:"filename"`   // Nombre destino en shared/
+	Size      int64  `json:"size"`       // Tamaño total esperado
+	ChunkSize int    `json:"chunk_size"` // Tamaño de bloque usado por el emisor
+	Received  int64  `json:"received"`   // Bytes verificados en el archivo parcial
+	Updated   int64  `json:"updated"`    // Última actualización (Unix)
+}
+
+// PartialPath retorna la ruta del archivo parcial de una transferencia
+func PartialPath(hash string) string {
+	return filepath.Join(partialDir, hash+".part")
+}
+
+// ValidHash indica si s es un SHA-256 en hexadecimal (seguro como nombre de archivo)
+func ValidHash(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == 32
+}
+
+// GetTransferState busca la transferencia parcial asociada a un hash
+func GetTransferState(hash string) (TransferState, bool) {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+
+	states, err := loadJournal()
+	if err != nil {
+		return TransferState{}, false
+	}
+	state, ok := states[hash]
+	return state, ok
+}
+
+// UpdateTransferState guarda el progreso de una transferencia
+func UpdateTransferState(state TransferState) error {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+
+	states, _ := loadJournal()
+	states[state.Hash] = state
+	return saveJournal(states)
+}
+
+// RemoveTransferState elimina una transferencia del diario y su archivo parcial
+func RemoveTransferState(hash string) error {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+
+	os.Remove(PartialPath(hash))
+
+	states, _ := loadJournal()
+	if _, ok := states[hash]; !ok {
+		return nil
+	}
+	delete(states, hash)
+	return saveJournal(states)
+}
+
+// loadJournal lee transfers.json; el llamador debe tener journalMu
+func loadJournal() (map[string]TransferState, error) {
+	states := make(map[string]TransferState)
+
+	data, err := os.ReadFile(journalFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return states, nil
+		}
+		return states, fmt.Errorf("error al leer diario de transferencias: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &states); err != nil {
+		return make(map[string]TransferState), fmt.Errorf("formato inválido en diario de transferencias: %v", err)
+	}
+	return states, nil
+}
+
+// saveJournal sobrescribe transfers.json; el llamador debe tener journalMu
+func saveJournal(states map[string]TransferState) error {
+	if err := os.MkdirAll(filepath.Dir(journalFile), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(journalFile, data, 0644)
+}
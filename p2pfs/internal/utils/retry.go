@@ -13,18 +13,36 @@ var mu sync.Mutex
 
 // PendingTask representa una tarea que no se pudo completar (ej. TRANSFER, DELETE)
 type PendingTask struct {
-	Type     string `json:"type"`     // Ej. "TRANSFER", "DELETE"
-	FilePath string `json:"filepath"` // Ruta local del archivo
-	Target   string `json:"target"`   // IP:puerto destino
-	Retries  int    `json:"retries"`  // Número de intentos fallidos previos
+	Type     string `json:"type"`                // Ej. "TRANSFER", "DELETE"
+	FilePath string `json:"filepath"`            // Ruta local del archivo
+	Target   string `json:"target"`              // IP:puerto destino (última dirección conocida)
+	TargetID string `json:"target_id,omitempty"` // NodeID destino; manda sobre Target
+	Retries  int    `json:"retries"`             // Número de intentos fallidos previos
 }
 
-// AddPendingTask agrega una nueva tarea a retry_queue.json
+// sameTask indica si a y b son la misma tarea (mismo tipo, archivo y destino)
+func sameTask(a, b PendingTask) bool {
+	if a.Type != b.Type || a.FilePath != b.FilePath {
+		return false
+	}
+	if a.TargetID != "" || b.TargetID != "" {
+		return a.TargetID == b.TargetID
+	}
+	return a.Target == b.Target
+}
+
+// AddPendingTask agrega una nueva tarea a retry_queue.json. Si ya hay una
+// igual pendiente no se duplica.
 func AddPendingTask(task PendingTask) error {
 	mu.Lock()
 	defer mu.Unlock()
 
 	tasks, _ := LoadRetryQueue()
+	for _, t := range tasks {
+		if sameTask(t, task) {
+			return nil
+		}
+	}
 	tasks = append(tasks, task)
 
 	if err := saveRetryQueue(tasks); err != nil {
@@ -67,6 +85,40 @@ func saveRetryQueue(tasks []PendingTask) error {
 	return os.WriteFile(retryFile, data, 0644)
 }
 
+// RemovePendingTask quita de la cola una tarea completada
+func RemovePendingTask(task PendingTask) error {
+	return updatePendingTask(task, func(*PendingTask) bool { return false })
+}
+
+// RecordRetryFailure suma un intento fallido a una tarea de la cola
+func RecordRetryFailure(task PendingTask) error {
+	return updatePendingTask(task, func(t *PendingTask) bool {
+		t.Retries++
+		return true
+	})
+}
+
+// updatePendingTask aplica fn a la tarea igual a task; si fn retorna false
+// la tarea se elimina. Se relee la cola para no pisar tareas agregadas
+// mientras se reintentaba.
+func updatePendingTask(task PendingTask, fn func(*PendingTask) bool) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	tasks, err := LoadRetryQueue()
+	if err != nil {
+		return err
+	}
+	kept := tasks[:0]
+	for _, t := range tasks {
+		if sameTask(t, task) && !fn(&t) {
+			continue
+		}
+		kept = append(kept, t)
+	}
+	return saveRetryQueue(kept)
+}
+
 // SaveRetryQueue guarda la lista actualizada de tareas
 func SaveRetryQueue(tasks []PendingTask) error {
 	mu.Lock()
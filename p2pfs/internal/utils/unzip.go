@@ -2,13 +2,16 @@ package utils
 
 import (
     "archive/zip"
+    "fmt"
     "io"
     "os"
     "path/filepath"
+    "strings"
 )
 
-// UnzipFile descomprime un archivo zip a la carpeta destino.
-func UnzipFile(zipPath, destDir string) error {
+// UnzipFile descomprime un archivo zip a la carpeta destino. Si written no
+// es nil se llama con cada archivo o carpeta que se escribe.
+func UnzipFile(zipPath, destDir string, written func(path string)) error {
     r, err := zip.OpenReader(zipPath)
     if err != nil {
         return err
@@ -25,6 +28,9 @@ func UnzipFile(zipPath, destDir string) error {
 
         if f.FileInfo().IsDir() {
             os.MkdirAll(fpath, os.ModePerm)
+            if written != nil {
+                written(fpath)
+            }
             continue
         }
 
@@ -50,6 +56,9 @@ func UnzipFile(zipPath, destDir string) error {
         if err != nil {
             return err
         }
+        if written != nil {
+            written(fpath)
+        }
     }
 
     return nil
@@ -5,7 +5,6 @@ import (
     "io"
     "os"
     "path/filepath"
-    "strings"
 )
 
 // ZipFolder comprime el directorio source y lo guarda como archivo ZIP en target.